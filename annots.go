@@ -0,0 +1,296 @@
+package gofpdi
+
+import (
+	"bytes"
+	"fmt"
+
+	src "github.com/speedata/pdfdisassembler"
+)
+
+// Annotation is an imported page annotation, ready to be listed in the /Annots
+// array of the host page that places the template.
+type Annotation struct {
+	// ObjID is the output object number of the copied annotation dictionary.
+	ObjID int
+	// Subtype is the annotation type, e.g. "Link", "Text" or "Stamp".
+	Subtype string
+	// Rect is the /Rect written into the copy, in host page space.
+	Rect [4]float64
+	// Dest is the target of a link within its source (/Dest or a /GoTo
+	// action), or nil.
+	Dest *ResolvedDest
+}
+
+// annotSet is one staged copy of a template's annotations, placed with a
+// host transformation. The same template placed twice needs two sets, since
+// every copy carries its own rectangles.
+type annotSet struct {
+	tpl       int
+	placement Matrix
+	annots    []Annotation // filled by PutFormXobjects
+}
+
+// annotPointKeys are the annotation entries holding flat [x1 y1 x2 y2 …]
+// coordinate lists in default user space.
+var annotPointKeys = map[string]bool{
+	"QuadPoints": true, // text markup, links
+	"Vertices":   true, // polygon, polyline
+	"L":          true, // line
+	"CL":         true, // free-text callout
+}
+
+// stageAnnots stages the annotations of template tplN and returns the index
+// of the new annotation set.
+func (pw *PdfWriter) stageAnnots(tplN int, placement Matrix) (int, error) {
+	if tplN < 0 || tplN >= len(pw.tpls) {
+		return 0, fmt.Errorf("gofpdi: unknown template %d", tplN)
+	}
+	pw.annotSets = append(pw.annotSets, &annotSet{tpl: tplN, placement: placement})
+	return len(pw.annotSets) - 1, nil
+}
+
+// writeAnnotSet copies the annotations of one set. Every annotation dictionary
+// is written afresh, with its coordinates mapped through the template's form
+// matrix and the set's placement; what they reference (appearance streams,
+// popups) goes through assignRef and drain as usual.
+func (pw *PdfWriter) writeAnnotSet(set *annotSet) error {
+	tpl := pw.tpls[set.tpl]
+	pw.source = tpl.source
+	m := tplMatrix(tpl).Multiply(set.placement)
+
+	r := pw.sources[tpl.source]
+	annotsObj, arrOwner := resolveOwned(r, entry(tpl.page.Dict(), "Annots"), pw.crypt(tpl.source).pageRef(tpl.page.Index()))
+	arr, _ := annotsObj.(src.Array)
+	type pending struct {
+		dict  *src.Dict
+		owner src.Reference
+		objID int
+	}
+	var annots []pending
+	// Reserve all numbers first so annotations of the set that refer to each
+	// other (/Popup, /Parent, /IRT) point at the copies of this set.
+	pw.refOverride = make(map[sourceRef]int)
+	pw.nullPages = true
+	defer func() { pw.refOverride, pw.nullPages = nil, false }()
+	for _, entry := range arr {
+		obj, err := r.Resolve(entry)
+		if err != nil {
+			return fmt.Errorf("gofpdi: resolve annotation: %w", err)
+		}
+		d, ok := obj.(*src.Dict)
+		if !ok || tpl.flattened[d] || !pw.keepAnnot(tpl, d) {
+			continue
+		}
+		id := pw.reserveObjectID()
+		owner := arrOwner
+		if ref, ok := entry.(src.Reference); ok {
+			pw.refOverride[sourceRef{source: tpl.source, ref: ref}] = id
+			owner = ref
+		}
+		annots = append(annots, pending{dict: d, owner: owner, objID: id})
+	}
+
+	for _, a := range annots {
+		pw.currentObj = new(bytes.Buffer)
+		pw.owner = a.owner
+		rect, dest := pw.writeAnnot(a.dict, m)
+		if pw.err != nil {
+			return pw.err
+		}
+		if err := pw.emit(a.objID); err != nil {
+			return err
+		}
+		sub, _ := a.dict.Name("Subtype")
+		set.annots = append(set.annots, Annotation{ObjID: a.objID, Subtype: string(sub), Rect: rect, Dest: dest})
+	}
+	return pw.drain()
+}
+
+// writeAnnot serializes an annotation dictionary with its coordinates
+// transformed by m and returns the new /Rect and the resolved link target.
+// /P (the source page) and /StructParent (a key into the source's parent
+// tree) are dropped; the host sets them for the page the annotation ends up
+// on. Link targets are rewritten by destFunc when it is set.
+func (pw *PdfWriter) writeAnnot(d *src.Dict, m Matrix) ([4]float64, *ResolvedDest) {
+	b := pw.currentObj
+	var rect [4]float64
+	var dest *ResolvedDest
+	b.WriteString("<<")
+	for k, v := range d.Iter() {
+		switch {
+		case k == "P" || k == "StructParent":
+			continue
+		case k == "Dest" || k == "A":
+			target, owner := v, pw.owner
+			if k == "A" {
+				target, owner = actionDest(pw.sources[pw.source], v, owner)
+			}
+			rd, ok := pw.resolveDest(pw.source, target, owner)
+			if !ok {
+				break
+			}
+			dest = &rd
+			if pw.destFunc == nil {
+				break
+			}
+			if repl, ok := pw.destFunc(rd); ok {
+				if k == "A" {
+					// Keep the rest of the action, /Next included.
+					action, _ := resolveIn(pw.sources[pw.source], v).(*src.Dict)
+					annotOwner := pw.owner
+					pw.owner = owner
+					b.WriteString("/A <<")
+					for ak, av := range action.Iter() {
+						b.WriteString("/" + escapeName(ak) + " ")
+						if ak == "D" {
+							b.WriteString(repl + " ")
+						} else {
+							pw.writeObject(av)
+						}
+					}
+					b.WriteString(">>")
+					pw.owner = annotOwner
+				} else {
+					b.WriteString("/Dest " + repl)
+				}
+				continue
+			}
+		case k == "Rect":
+			r, _ := pw.numbers(v)
+			if len(r) == 4 {
+				rect = m.transformRect([4]float64{r[0], r[1], r[2], r[3]})
+				b.WriteString("/Rect ")
+				pw.writeNumbers(rect[:])
+				continue
+			}
+		case annotPointKeys[k]:
+			if pts, ok := pw.numbers(v); ok {
+				b.WriteString("/" + k + " ")
+				pw.writeNumbers(transformPoints(m, pts))
+				continue
+			}
+		case k == "InkList":
+			if paths, ok := pw.resolve(v).(src.Array); ok {
+				b.WriteString("/InkList [")
+				for _, path := range paths {
+					pts, _ := pw.numbers(path)
+					pw.writeNumbers(transformPoints(m, pts))
+				}
+				b.WriteString("]")
+				continue
+			}
+		}
+		b.WriteString("/" + escapeName(k) + " ")
+		pw.writeObject(v)
+	}
+	b.WriteString(">>")
+	return rect, dest
+}
+
+// keepAnnot reports whether annotation d of tpl is copied: annotations
+// outside a WithClip rectangle and those touching a redacted area are not.
+func (pw *PdfWriter) keepAnnot(tpl *pdfTemplate, d *src.Dict) bool {
+	r := pw.sources[tpl.source]
+	box := tpl.box
+	if tpl.clip && !overlapsBox(r, d, [4]float64{box["llx"], box["lly"], box["urx"], box["ury"]}) {
+		return false
+	}
+	for _, region := range tpl.redactions {
+		if overlapsBox(r, d, region) {
+			return false
+		}
+	}
+	return true
+}
+
+// overlapsBox reports whether the /Rect of annotation d intersects box.
+// Annotations without a usable /Rect count as overlapping.
+func overlapsBox(r *src.Reader, d *src.Dict, box [4]float64) bool {
+	rect, ok := dictNumbers(r, d, "Rect")
+	if !ok || len(rect) != 4 {
+		return true
+	}
+	return overlaps(normalizeRect([4]float64{rect[0], rect[1], rect[2], rect[3]}), box)
+}
+
+// overlaps reports whether the normalized rectangles a and b intersect.
+func overlaps(a, b [4]float64) bool {
+	return a[0] < b[2] && a[2] > b[0] && a[1] < b[3] && a[3] > b[1]
+}
+
+// transformPoints maps a flat [x1 y1 x2 y2 …] list through m.
+func transformPoints(m Matrix, pts []float64) []float64 {
+	out := make([]float64, len(pts))
+	for i := 0; i+1 < len(pts); i += 2 {
+		out[i], out[i+1] = m.Apply(pts[i], pts[i+1])
+	}
+	return out
+}
+
+// resolve dereferences obj in the current source; unresolvable objects
+// become Null.
+func (pw *PdfWriter) resolve(obj src.Object) src.Object {
+	return resolveIn(pw.sources[pw.source], obj)
+}
+
+// numbers resolves obj in the current source to an array of numbers.
+func (pw *PdfWriter) numbers(obj src.Object) ([]float64, bool) {
+	return numbersIn(pw.sources[pw.source], obj)
+}
+
+// resolveIn dereferences obj in r; missing and unresolvable objects become
+// Null.
+func resolveIn(r *src.Reader, obj src.Object) src.Object {
+	if obj == nil {
+		return src.Null{}
+	}
+	v, err := r.Resolve(obj)
+	if err != nil {
+		return src.Null{}
+	}
+	return v
+}
+
+// numbersIn resolves obj in r to an array of numbers.
+func numbersIn(r *src.Reader, obj src.Object) ([]float64, bool) {
+	arr, ok := resolveIn(r, obj).(src.Array)
+	if !ok {
+		return nil, false
+	}
+	out := make([]float64, 0, len(arr))
+	for _, e := range arr {
+		f, ok := number(resolveIn(r, e))
+		if !ok {
+			return nil, false
+		}
+		out = append(out, f)
+	}
+	return out, true
+}
+
+// dictNumbers returns the entry key of d as an array of numbers.
+func dictNumbers(r *src.Reader, d *src.Dict, key string) ([]float64, bool) {
+	v, _ := d.Get(key)
+	return numbersIn(r, v)
+}
+
+// writeNumbers writes a PDF array of computed reals, rounded to five decimals
+// so transformation noise does not leak into the output.
+func (pw *PdfWriter) writeNumbers(nums []float64) {
+	pw.currentObj.WriteByte('[')
+	for _, f := range nums {
+		pw.currentObj.WriteString(formatNumber(f) + " ")
+	}
+	pw.currentObj.WriteByte(']')
+}
+
+// number returns the value of an Integer or Real object.
+func number(obj src.Object) (float64, bool) {
+	switch n := obj.(type) {
+	case src.Integer:
+		return float64(n), true
+	case src.Real:
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,180 @@
+package gofpdi
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	src "github.com/speedata/pdfdisassembler"
+)
+
+func TestImportAnnots(t *testing.T) {
+	pdf := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R 7 0 R] /Count 2>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] /Contents 4 0 R /Annots [5 0 R 6 0 R]>>",
+		"<</Length 0>>\nstream\n\nendstream",
+		"<</Type /Annot /Subtype /Link /Rect [10 10 50 20] /QuadPoints [10 10 50 10 50 20 10 20] /Dest [7 0 R /Fit] /P 3 0 R>>",
+		"<</Type /Annot /Subtype /Text /Rect [100 50 120 70] /Contents (note) /Popup 8 0 R /P 3 0 R>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] /Contents 4 0 R>>",
+		"<</Type /Annot /Subtype /Popup /Rect [120 50 180 90] /Parent 6 0 R>>",
+	}, "")
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(bytes.NewReader(pdf)); err != nil {
+		t.Fatal(err)
+	}
+	tpl, err := imp.ImportPage(1, "/MediaBox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Placed at half size, moved to (300, 400).
+	set, err := imp.ImportAnnots(tpl, Matrix{0.5, 0, 0, 0.5, 300, 400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := imp.ImportAnnots(tpl+1, IdentityMatrix); err == nil {
+		t.Error("ImportAnnots with an unknown template should fail")
+	}
+	if _, err := imp.PutFormXobjects(); err != nil {
+		t.Fatal(err)
+	}
+	annots := imp.GetImportedAnnots(set)
+	if len(annots) != 2 {
+		t.Fatalf("got %d annotations, want 2", len(annots))
+	}
+	link, note := annots[0], annots[1]
+	if link.Subtype != "Link" || note.Subtype != "Text" {
+		t.Errorf("subtypes = %q, %q", link.Subtype, note.Subtype)
+	}
+	if want := [4]float64{305, 405, 325, 410}; link.Rect != want {
+		t.Errorf("link /Rect = %v, want %v", link.Rect, want)
+	}
+
+	objs := imp.GetImportedObjects()
+	body := string(objs[link.ObjID])
+	if !strings.Contains(body, "/QuadPoints [305 405 325 405 325 410 305 410 ]") {
+		t.Errorf("QuadPoints not transformed: %s", body)
+	}
+	if !strings.Contains(body, "/Dest [null /Fit ]") {
+		t.Errorf("reference to a source page not nulled: %s", body)
+	}
+	if strings.Contains(body, "/P ") {
+		t.Errorf("/P not dropped: %s", body)
+	}
+	// The popup is copied and points back at the copy of its parent.
+	noteBody := string(objs[note.ObjID])
+	var popup int
+	for n, b := range objs {
+		if strings.Contains(string(b), "/Subtype /Popup") {
+			popup = n
+		}
+	}
+	if popup == 0 || !strings.Contains(noteBody, "/Popup "+itoa(popup)+" 0 R") {
+		t.Fatalf("popup not copied: %s", noteBody)
+	}
+	if !strings.Contains(string(objs[popup]), "/Parent "+itoa(note.ObjID)+" 0 R") {
+		t.Errorf("popup /Parent does not point at the copied note: %s", objs[popup])
+	}
+}
+
+func TestImportAnnotsRetarget(t *testing.T) {
+	pdf := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R /Names <</Dests <</Names [(end) [4 0 R /Fit]]>>>>>>",
+		"<</Type /Pages /Kids [3 0 R 4 0 R] /Count 2>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] /Annots [5 0 R 6 0 R]>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [10 10 210 110]>>",
+		"<</Type /Annot /Subtype /Link /Rect [0 0 10 10] /Dest (end)>>",
+		"<</Type /Annot /Subtype /Link /Rect [0 0 10 10] /A <</S /GoTo /D [4 0 R /XYZ 15 60 null] /Next <</S /URI /URI (https://example.com)>>>>>>",
+	}, "")
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(bytes.NewReader(pdf)); err != nil {
+		t.Fatal(err)
+	}
+	tpl, _ := imp.ImportPage(1, "/MediaBox")
+	if _, err := imp.ImportPage(2, "/MediaBox"); err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := imp.ResolveNamedDest(0, "end")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rd.Page != 2 || rd.Template != 1 || rd.Fit != "Fit" {
+		t.Errorf("ResolveNamedDest = %+v", rd)
+	}
+	if _, err := imp.ResolveNamedDest(0, "nowhere"); err == nil {
+		t.Error("ResolveNamedDest of an unknown name should fail")
+	}
+	rd, err = imp.ResolveDest(0, src.Array{src.Reference{Number: 4}, src.Name("FitH"), src.Integer(60)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rd.Page != 2 || rd.Template != 1 || rd.Fit != "FitH" || rd.Top != 50 {
+		t.Errorf("ResolveDest = %+v", rd)
+	}
+
+	// The host places template 1 on its page object 99.
+	imp.SetDestinationFunc(func(rd ResolvedDest) (string, bool) {
+		if rd.Template != 1 {
+			return "", false
+		}
+		if rd.Fit == "XYZ" {
+			return "[99 0 R /XYZ " + itoa(int(rd.Left)) + " " + itoa(int(rd.Top)) + " null]", true
+		}
+		return "[99 0 R /Fit]", true
+	})
+	set, _ := imp.ImportAnnots(tpl, IdentityMatrix)
+	if _, err := imp.PutFormXobjects(); err != nil {
+		t.Fatal(err)
+	}
+	annots := imp.GetImportedAnnots(set)
+	objs := imp.GetImportedObjects()
+	if body := string(objs[annots[0].ObjID]); !strings.Contains(body, "/Dest [99 0 R /Fit]") {
+		t.Errorf("named destination not retargeted: %s", body)
+	}
+	// The box origin (10, 10) moves to the form origin.
+	if body := string(objs[annots[1].ObjID]); !strings.Contains(body, "/A <</S /GoTo /D [99 0 R /XYZ 5 50 null] /Next <</S /URI /URI (https://example.com)>>>>") {
+		t.Errorf("GoTo action not retargeted: %s", body)
+	}
+	if d := annots[1].Dest; d == nil || d.Page != 2 || d.Left != 5 || d.Top != 50 {
+		t.Errorf("Annotation.Dest = %+v", d)
+	}
+}
+
+// Template resources keep referencing source pages, as they always did; only
+// annotation and structure copies write such references as null.
+func TestTemplatePageReferences(t *testing.T) {
+	pdf := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] /Contents 4 0 R /Resources <</Properties <</MC0 5 0 R>>>>>>",
+		"<</Length 0>>\nstream\n\nendstream",
+		"<</Dest [3 0 R /Fit]>>",
+	}, "")
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(bytes.NewReader(pdf)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := imp.ImportPage(1, "/MediaBox"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := imp.PutFormXobjects(); err != nil {
+		t.Fatal(err)
+	}
+	objs := imp.GetImportedObjects()
+	var prop, page int
+	for n, b := range objs {
+		switch {
+		case strings.Contains(string(b), "/Dest "):
+			prop = n
+		case strings.Contains(string(b), "/Type /Page "):
+			page = n
+		}
+	}
+	if page == 0 || !strings.Contains(string(objs[prop]), "/Dest ["+itoa(page)+" 0 R /Fit ]") {
+		t.Errorf("page reference in the resources not copied: %s", objs[prop])
+	}
+}
@@ -0,0 +1,225 @@
+// Command gofpdi merges, splits and inspects PDF files with the gofpdi
+// importer.
+//
+//	gofpdi merge -o out.pdf a.pdf b.pdf:1-3,7 c.pdf:2-end
+//	gofpdi split -o part -n 2 in.pdf      writes part-001.pdf, part-002.pdf, …
+//	gofpdi extract -o out.pdf -pages 5-1 in.pdf
+//	gofpdi info in.pdf
+//
+// Page ranges follow gofpdi.ParsePageRange. All commands except info accept
+// -box to choose the page box that delimits each imported page; all accept
+// -password to open encrypted files.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/boxesandglue/gofpdi"
+)
+
+const usage = `usage: gofpdi <command> [flags] files…
+
+commands:
+  merge   -o out.pdf in.pdf[:range]…   concatenate pages of several files
+  split   -o prefix [-n pages] in.pdf  write every n pages to a file of its own
+  extract -o out.pdf -pages range in.pdf
+  info    in.pdf…                       print page count, boxes and rotation
+
+-password opens encrypted input files.
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "gofpdi:", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command line args, writing reports to stdout.
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n%s", usage)
+	}
+	switch args[0] {
+	case "merge", "split", "extract", "info":
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	out := fs.String("o", "", "output file (merge, extract) or file name prefix (split)")
+	box := fs.String("box", "/MediaBox", "page box delimiting the imported pages")
+	pages := fs.String("pages", "", "page range (extract)")
+	n := fs.Int("n", 1, "pages per file (split)")
+	password := fs.String("password", "", "password of encrypted input files")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	files := fs.Args()
+	if len(files) == 0 {
+		return fmt.Errorf("%s: no input files", args[0])
+	}
+	if *out == "" && args[0] != "info" {
+		return fmt.Errorf("%s: -o is required", args[0])
+	}
+
+	switch args[0] {
+	case "merge":
+		var inputs []input
+		for _, arg := range files {
+			name, spec := splitRange(arg)
+			inputs = append(inputs, input{name: name, pages: spec})
+		}
+		return assemble(*out, *box, *password, inputs)
+	case "extract":
+		if *pages == "" {
+			return fmt.Errorf("extract: -pages is required")
+		}
+		return assemble(*out, *box, *password, []input{{name: files[0], pages: *pages}})
+	case "split":
+		return split(*out, *box, *password, files[0], *n)
+	case "info":
+		for _, name := range files {
+			if err := info(stdout, name, *password); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// input is a source file with an optional page range ("" for all pages).
+type input struct {
+	name, pages string
+}
+
+// splitRange separates "file.pdf:range" into file name and range. A colon
+// that is part of an existing file name is left alone.
+func splitRange(arg string) (string, string) {
+	if _, err := os.Stat(arg); err == nil {
+		return arg, ""
+	}
+	if i := strings.LastIndexByte(arg, ':'); i > 0 {
+		return arg[:i], arg[i+1:]
+	}
+	return arg, ""
+}
+
+// assemble writes the selected pages of inputs, in order, to out. A file
+// listed several times is read once.
+func assemble(out, box, password string, inputs []input) error {
+	doc := gofpdi.NewDocument()
+	imp := doc.Importer()
+	imp.SetSourcePassword(password)
+	sources := make(map[string]int)
+	for _, in := range inputs {
+		source, ok := sources[in.name]
+		if !ok {
+			f, err := os.Open(in.name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if source, err = imp.AddSourceStream(f); err != nil {
+				return fmt.Errorf("%s: %w", in.name, err)
+			}
+			sources[in.name] = source
+		}
+		spec := in.pages
+		if spec == "" {
+			spec = "1-end"
+		}
+		tpls, _, err := imp.ImportPagesFrom(source, spec, box)
+		if err != nil {
+			return fmt.Errorf("%s: %w", in.name, err)
+		}
+		for _, tpl := range tpls {
+			if _, err := doc.AddTemplatePage(tpl); err != nil {
+				return err
+			}
+		}
+	}
+	return writeFile(out, doc)
+}
+
+// split writes every n pages of name to a file prefix-NNN.pdf of its own.
+func split(prefix, box, password, name string, n int) error {
+	if n < 1 {
+		return fmt.Errorf("split: -n must be positive")
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	probe := gofpdi.NewImporter()
+	probe.SetSourcePassword(password)
+	if err := probe.SetSourceStream(f); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	numPages, err := probe.GetNumPages()
+	if err != nil {
+		return err
+	}
+	for first, part := 1, 1; first <= numPages; first, part = first+n, part+1 {
+		last := min(first+n-1, numPages)
+		spec := fmt.Sprintf("%d-%d", first, last)
+		if err := assemble(fmt.Sprintf("%s-%03d.pdf", prefix, part), box, password, []input{{name: name, pages: spec}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// info prints the page count and the boxes and rotation of every page.
+func info(w io.Writer, name, password string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	imp := gofpdi.NewImporter()
+	imp.SetSourcePassword(password)
+	if err := imp.SetSourceStream(f); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	sizes, err := imp.GetPageSizes()
+	if err != nil {
+		return err
+	}
+	rotations, err := imp.GetPageRotations()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%s: %d pages\n", name, len(sizes))
+	for p := 1; p <= len(sizes); p++ {
+		fmt.Fprintf(w, "  page %d %-10s %d\n", p, "/Rotate", rotations[p])
+		boxes := make([]string, 0, len(sizes[p]))
+		for b := range sizes[p] {
+			boxes = append(boxes, b)
+		}
+		sort.Strings(boxes)
+		for _, b := range boxes {
+			r := sizes[p][b]
+			fmt.Fprintf(w, "  page %d %-10s [%g %g %g %g] %gx%g\n", p, b, r["llx"], r["lly"], r["urx"], r["ury"], r["w"], r["h"])
+		}
+	}
+	return nil
+}
+
+// writeFile writes doc to the file name.
+func writeFile(name string, doc *gofpdi.Document) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := doc.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rc4"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeAndInfo(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.pdf")
+	sample := filepath.Join("..", "..", "testdata", "sample.pdf")
+	cow := filepath.Join("..", "..", "testdata", "cow.pdf")
+	if err := run([]string{"merge", "-o", out, sample + ":end", cow}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := run([]string{"info", out}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), ": 2 pages\n") || !strings.Contains(buf.String(), "page 2 /MediaBox  [0 0 275 200]") {
+		t.Errorf("info output:\n%s", buf.String())
+	}
+	if err := run([]string{"extract", "-o", out, "-pages", "3", sample}, nil); err == nil {
+		t.Error("extract of a missing page succeeded")
+	}
+}
+
+func TestSplitAndExtract(t *testing.T) {
+	dir := t.TempDir()
+	merged := filepath.Join(dir, "merged.pdf")
+	sample := filepath.Join("..", "..", "testdata", "sample.pdf")
+	cow := filepath.Join("..", "..", "testdata", "cow.pdf")
+	if err := run([]string{"merge", "-o", merged, sample, cow}, nil); err != nil {
+		t.Fatal(err)
+	}
+	report := func(name string) string {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := run([]string{"info", name}, &buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	// Three pages, two per file: the cow page ends up alone in the second.
+	prefix := filepath.Join(dir, "part")
+	if err := run([]string{"split", "-o", prefix, "-n", "2", merged}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if r := report(prefix + "-001.pdf"); !strings.Contains(r, ": 2 pages\n") || strings.Contains(r, "275") {
+		t.Errorf("first part:\n%s", r)
+	}
+	if r := report(prefix + "-002.pdf"); !strings.Contains(r, ": 1 pages\n") || !strings.Contains(r, "page 1 /MediaBox  [0 0 275 200]") {
+		t.Errorf("second part:\n%s", r)
+	}
+	if _, err := os.Stat(prefix + "-003.pdf"); err == nil {
+		t.Error("split wrote a third part")
+	}
+
+	out := filepath.Join(dir, "out.pdf")
+	if err := run([]string{"extract", "-o", out, "-pages", "3,1", merged}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if r := report(out); !strings.Contains(r, ": 2 pages\n") || !strings.Contains(r, "page 1 /MediaBox  [0 0 275 200]") ||
+		!strings.Contains(r, "page 2 /MediaBox  [0 0 595 842]") {
+		t.Errorf("extracted pages:\n%s", r)
+	}
+}
+
+func TestInfoRotation(t *testing.T) {
+	name := writeTestPDF(t, []string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1 /Rotate 90>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 100]>>",
+	}, "")
+	var buf bytes.Buffer
+	if err := run([]string{"info", name}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "page 1 /Rotate    90\n") {
+		t.Errorf("info output:\n%s", buf.String())
+	}
+}
+
+// writeTestPDF writes a PDF of the object bodies objs, numbered from 1, with
+// the extra trailer entries given, and returns its file name.
+func writeTestPDF(t *testing.T, objs []string, trailer string) string {
+	t.Helper()
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.7\n")
+	var offsets []int
+	for i, o := range objs {
+		offsets = append(offsets, pdf.Len())
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<</Size %d /Root 1 0 R%s>>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, trailer, xref)
+	name := filepath.Join(t.TempDir(), "test.pdf")
+	if err := os.WriteFile(name, pdf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestPassword(t *testing.T) {
+	// Revision 2 of the standard security handler, user password "secret".
+	pad := []byte("\x28\xbf\x4e\x5e\x4e\x75\x8a\x41\x64\x00\x4e\x56\xff\xfa\x01\x08" +
+		"\x2e\x2e\x00\xb6\xd0\x68\x3e\x80\x2f\x0c\xa9\xfe\x64\x53\x69\x7a")
+	rc4Crypt := func(key, data []byte) []byte {
+		c, _ := rc4.NewCipher(key)
+		out := make([]byte, len(data))
+		c.XORKeyStream(out, data)
+		return out
+	}
+	user := append([]byte("secret"), pad[:32-len("secret")]...)
+	ownerKey := md5.Sum(append([]byte("owner"), pad[:32-len("owner")]...))
+	o := rc4Crypt(ownerKey[:5], user)
+	id := []byte("0123456789abcdef")
+	fileKey := md5.Sum(bytes.Join([][]byte{user, o, {0xfc, 0xff, 0xff, 0xff}, id}, nil))
+	u := rc4Crypt(fileKey[:5], pad)
+	name := writeTestPDF(t, []string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] /Rotate 90>>",
+		fmt.Sprintf("<</Filter /Standard /V 1 /R 2 /Length 40 /P -4 /O <%x> /U <%x>>>", o, u),
+	}, fmt.Sprintf(" /Encrypt 4 0 R /ID [<%x> <%x>]", id, id))
+
+	if err := run([]string{"info", name}, &bytes.Buffer{}); err == nil {
+		t.Error("info of an encrypted file without -password succeeded")
+	}
+	var buf bytes.Buffer
+	if err := run([]string{"info", "-password", "secret", name}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "page 1 /Rotate    90\n") {
+		t.Errorf("info output:\n%s", buf.String())
+	}
+	out := filepath.Join(t.TempDir(), "out.pdf")
+	if err := run([]string{"merge", "-password", "secret", "-o", out, name}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeSameFile(t *testing.T) {
+	// A file listed twice is one source: its page is imported once and
+	// placed on two pages.
+	sample := filepath.Join("..", "..", "testdata", "sample.pdf")
+	dir := t.TempDir()
+	once, twice := filepath.Join(dir, "once.pdf"), filepath.Join(dir, "twice.pdf")
+	if err := run([]string{"merge", "-o", once, sample + ":1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"merge", "-o", twice, sample + ":1", sample + ":1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	count := func(name string) int {
+		t.Helper()
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bytes.Count(b, []byte(" 0 obj\n"))
+	}
+	// One more page object and its content stream.
+	if n, m := count(once), count(twice); m != n+2 {
+		t.Errorf("merging the page twice writes %d objects, once %d", m, n)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	if err := run([]string{"bogus", "in.pdf"}, nil); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("err = %v, want an unknown command error", err)
+	}
+}
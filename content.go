@@ -0,0 +1,194 @@
+package gofpdi
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/speedata/pdfdisassembler/contentstream"
+)
+
+// ContentOp is one operation of a decoded content stream: its operands
+// followed by the operator keyword, e.g. [/F1 12] Tf.
+//
+// An inline image is a single operation with Operator "EI": Operands[0] is
+// its BI dictionary (OperandDict, possibly empty) and Image holds the bytes
+// between ID and EI.
+type ContentOp struct {
+	Operator string
+	Operands []Operand
+	Image    []byte
+}
+
+// OperandKind is the type of an Operand.
+type OperandKind int
+
+// The operand types of content streams.
+const (
+	OperandNull OperandKind = iota
+	OperandNumber
+	OperandName
+	OperandString
+	OperandArray
+	OperandDict
+	OperandBool
+)
+
+// Operand is one operand of a ContentOp. Kind selects the field that holds
+// the value.
+type Operand struct {
+	Kind   OperandKind
+	Number float64
+	Name   string // without the leading slash
+	Bytes  []byte // decoded string bytes, in the font's encoding
+	Array  []Operand
+	Dict   map[string]Operand
+	Bool   bool
+}
+
+// ContentFilter rewrites one operation of a template's content (see
+// WithContentFilter). It returns the operations to write in its place:
+// []ContentOp{op} keeps it, nil drops it.
+type ContentFilter func(op ContentOp) ([]ContentOp, error)
+
+// ParseContent splits a decoded content stream into its operations.
+func ParseContent(content []byte) ([]ContentOp, error) {
+	var ops []ContentOp
+	for op, err := range contentstream.New(content).All() {
+		if err != nil {
+			return nil, fmt.Errorf("gofpdi: parse content stream: %w", err)
+		}
+		ops = append(ops, ContentOp{Operator: op.Operator, Operands: operands(op.Operands), Image: op.Image})
+	}
+	return ops, nil
+}
+
+// operands converts scanner operands.
+func operands(in []contentstream.Operand) []Operand {
+	if in == nil {
+		return nil
+	}
+	out := make([]Operand, len(in))
+	for i, o := range in {
+		out[i] = operand(o)
+	}
+	return out
+}
+
+// operand converts a scanner operand.
+func operand(o contentstream.Operand) Operand {
+	switch o.Kind {
+	case contentstream.KindNumber:
+		return Operand{Kind: OperandNumber, Number: o.Number}
+	case contentstream.KindName:
+		return Operand{Kind: OperandName, Name: o.Name}
+	case contentstream.KindString:
+		return Operand{Kind: OperandString, Bytes: o.Bytes}
+	case contentstream.KindArray:
+		return Operand{Kind: OperandArray, Array: operands(o.Array)}
+	case contentstream.KindDict:
+		d := make(map[string]Operand, len(o.Dict))
+		for k, v := range o.Dict {
+			d[k] = operand(v)
+		}
+		return Operand{Kind: OperandDict, Dict: d}
+	case contentstream.KindBool:
+		return Operand{Kind: OperandBool, Bool: o.Bool}
+	}
+	return Operand{Kind: OperandNull}
+}
+
+// SerializeContent writes ops back in content stream syntax, one operation
+// per line.
+func SerializeContent(ops []ContentOp) []byte {
+	var b bytes.Buffer
+	for _, op := range ops {
+		writeOp(&b, op)
+	}
+	return b.Bytes()
+}
+
+// filterContent runs the content of tpl through filters, in order.
+func filterContent(tpl *pdfTemplate, filters []ContentFilter) error {
+	ops, err := ParseContent(tpl.content)
+	if err != nil {
+		return err
+	}
+	for _, f := range filters {
+		var out []ContentOp
+		for _, op := range ops {
+			repl, err := f(op)
+			if err != nil {
+				return fmt.Errorf("gofpdi: content filter: %w", err)
+			}
+			out = append(out, repl...)
+		}
+		ops = out
+	}
+	tpl.content = SerializeContent(ops)
+	return nil
+}
+
+// writeOp writes one operation. Inline images are written as BI … ID … EI.
+func writeOp(b *bytes.Buffer, op ContentOp) {
+	if op.Operator == "EI" {
+		b.WriteString("BI")
+		if len(op.Operands) > 0 {
+			d := op.Operands[0].Dict
+			for _, k := range slices.Sorted(maps.Keys(d)) {
+				b.WriteString(" /" + escapeName(k) + " ")
+				writeOperand(b, d[k])
+			}
+		}
+		b.WriteString(" ID ")
+		b.Write(op.Image)
+		b.WriteString("\nEI\n")
+		return
+	}
+	for _, o := range op.Operands {
+		writeOperand(b, o)
+		b.WriteByte(' ')
+	}
+	b.WriteString(op.Operator)
+	b.WriteByte('\n')
+}
+
+// writeOperand writes a single operand. Dictionary entries are written in
+// sorted order, since the scanner does not keep the original one.
+func writeOperand(b *bytes.Buffer, o Operand) {
+	switch o.Kind {
+	case OperandNumber:
+		s := strconv.FormatFloat(o.Number, 'f', -1, 64)
+		if s == "-0" {
+			s = "0"
+		}
+		b.WriteString(s)
+	case OperandName:
+		b.WriteString("/" + escapeName(o.Name))
+	case OperandString:
+		writeLiteral(b, o.Bytes)
+	case OperandArray:
+		b.WriteByte('[')
+		for i, e := range o.Array {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			writeOperand(b, e)
+		}
+		b.WriteByte(']')
+	case OperandDict:
+		b.WriteString("<<")
+		for _, k := range slices.Sorted(maps.Keys(o.Dict)) {
+			b.WriteString("/" + escapeName(k) + " ")
+			writeOperand(b, o.Dict[k])
+			b.WriteByte(' ')
+		}
+		b.WriteString(">>")
+	case OperandBool:
+		b.WriteString(strconv.FormatBool(o.Bool))
+	default:
+		b.WriteString("null")
+	}
+}
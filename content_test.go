@@ -0,0 +1,79 @@
+package gofpdi
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseContent(t *testing.T) {
+	in := "q 1 0 0 1 10.5 -3 cm /F1 12 Tf [(a) -250 (b)] TJ /Span <</ActualText (x) /MCID 2>> BDC EMC " +
+		"BI /W 1 /H 1 /CS /G /BPC 8 ID \x7f EI Q"
+	ops, err := ParseContent([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 8 {
+		t.Fatalf("%d operations, want 8", len(ops))
+	}
+	if op := ops[2]; op.Operator != "Tf" || op.Operands[0].Kind != OperandName || op.Operands[0].Name != "F1" || op.Operands[1].Number != 12 {
+		t.Errorf("Tf = %+v", op)
+	}
+	if tj := ops[3].Operands[0]; tj.Kind != OperandArray || len(tj.Array) != 3 || string(tj.Array[2].Bytes) != "b" {
+		t.Errorf("TJ operand = %+v", tj)
+	}
+	if props := ops[4].Operands[1]; props.Kind != OperandDict || props.Dict["MCID"].Number != 2 {
+		t.Errorf("BDC properties = %+v", props)
+	}
+	if img := ops[6]; img.Operator != "EI" || string(img.Image) != "\x7f" || img.Operands[0].Dict["CS"].Name != "G" {
+		t.Errorf("inline image = %+v", img)
+	}
+
+	want := "q\n1 0 0 1 10.5 -3 cm\n/F1 12 Tf\n[(a) -250 (b)] TJ\n/Span <</ActualText (x) /MCID 2 >> BDC\nEMC\n" +
+		"BI /BPC 8 /CS /G /H 1 /W 1 ID \x7f\nEI\nQ\n"
+	if got := string(SerializeContent(ops)); got != want {
+		t.Errorf("SerializeContent =\n%q\nwant\n%q", got, want)
+	}
+	if _, err := ParseContent([]byte("BI /W 1 ID")); err == nil {
+		t.Error("truncated inline image parsed")
+	}
+}
+
+func TestImportContentFilter(t *testing.T) {
+	r, err := os.Open("testdata/sample.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(r); err != nil {
+		t.Fatal(err)
+	}
+	// Drop the text and count what the filter saw.
+	var seen int
+	dropText := func(op ContentOp) ([]ContentOp, error) {
+		seen++
+		switch op.Operator {
+		case "Tj", "TJ", "'", "\"":
+			return nil, nil
+		}
+		return []ContentOp{op}, nil
+	}
+	tpl, err := imp.ImportPage(1, "/MediaBox", WithContentFilter(dropText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := imp.ImportPage(1, "/MediaBox", WithContentFilter(dropText)); again == tpl {
+		t.Error("filtered imports were deduplicated")
+	}
+	names, err := imp.PutFormXobjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, content := formContent(t, imp.GetImportedObjects()[names["/GOFPDITPL0"]])
+	if seen == 0 || strings.Contains(string(content), "Tj\n") || !bytes.Contains(content, []byte(" Tf\n")) {
+		t.Errorf("filtered content (%d operations seen):\n%s", seen, content)
+	}
+}
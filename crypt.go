@@ -0,0 +1,1071 @@
+package gofpdi
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rc4"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	src "github.com/speedata/pdfdisassembler"
+)
+
+// Encrypted sources are decrypted while they are copied. pdfdisassembler
+// opens a document only when its user password is empty and never decrypts
+// strings, so gofpdi keeps its own security handler per source (see
+// sourceCrypt): writeObject decrypts strings, writeStream the raw stream
+// bytes, and the few places that read text out of a source (destination
+// names, outline titles, layer names, form defaults) go through it as well.
+// Stream data stays filter-encoded.
+//
+// A source with a user password is opened through a small incremental update
+// appended in memory (openWithPassword) whose trailer swaps /Encrypt for an
+// identity-filter dictionary the empty password opens. pdfdisassembler then
+// leaves every byte alone; only the object streams, which it must parse
+// itself, are carried decrypted in the update.
+
+// cryptAlg is a stream or string cipher of the Standard security handler.
+type cryptAlg int
+
+const (
+	cryptIdentity cryptAlg = iota
+	cryptRC4
+	cryptAESV2 // AES-128, per-object key
+	cryptAESV3 // AES-256, file key
+)
+
+// securityHandler holds the file key and cipher choices of a source secured
+// with the Standard security handler (PDF 32000-1 §7.6.3, PDF 32000-2
+// §7.6.4). V1/V2 (RC4), V4 (RC4 or AES-128) and V5 (AES-256, R5 and R6) are
+// supported.
+type securityHandler struct {
+	key             []byte
+	stmAlg, strAlg  cryptAlg
+	filters         map[string]cryptAlg // /CF crypt filters by name
+	encryptMetadata bool
+}
+
+// passwordPad is the padding string of PDF 32000-1 §7.6.3.3 algorithm 2.
+var passwordPad = []byte{
+	0x28, 0xbf, 0x4e, 0x5e, 0x4e, 0x75, 0x8a, 0x41,
+	0x64, 0x00, 0x4e, 0x56, 0xff, 0xfa, 0x01, 0x08,
+	0x2e, 0x2e, 0x00, 0xb6, 0xd0, 0x68, 0x3e, 0x80,
+	0x2f, 0x0c, 0xa9, 0xfe, 0x64, 0x53, 0x69, 0x7a,
+}
+
+// newSecurityHandler validates password against the /Encrypt dictionary enc,
+// first as the user password and then as the owner password, and derives the
+// file key. id0 is the first element of the trailer /ID.
+func newSecurityHandler(enc *src.Dict, id0 []byte, password string) (*securityHandler, error) {
+	if f, _ := enc.Name("Filter"); f != "Standard" {
+		return nil, fmt.Errorf("gofpdi: security handler %q is not supported", f)
+	}
+	v, _ := enc.Int("V")
+	r, _ := enc.Int("R")
+	h := &securityHandler{
+		filters:         map[string]cryptAlg{"Identity": cryptIdentity},
+		encryptMetadata: true,
+	}
+	if em, ok := enc.Bool("EncryptMetadata"); ok {
+		h.encryptMetadata = em
+	}
+	o, _ := enc.Bytes("O")
+	u, _ := enc.Bytes("U")
+
+	switch v {
+	case 1, 2:
+		h.stmAlg, h.strAlg = cryptRC4, cryptRC4
+	case 4, 5:
+		if cf, ok := enc.Dict("CF"); ok {
+			for name, fv := range cf.Iter() {
+				fd, ok := fv.(*src.Dict)
+				if !ok {
+					continue
+				}
+				switch cfm, _ := fd.Name("CFM"); cfm {
+				case "V2":
+					h.filters[name] = cryptRC4
+				case "AESV2":
+					h.filters[name] = cryptAESV2
+				case "AESV3":
+					h.filters[name] = cryptAESV3
+				case "None":
+					h.filters[name] = cryptIdentity
+				default:
+					return nil, fmt.Errorf("gofpdi: crypt filter method %q is not supported", cfm)
+				}
+			}
+		}
+		stmF, _ := enc.Name("StmF")
+		strF, _ := enc.Name("StrF")
+		h.stmAlg = h.filters[string(stmF)] // missing -> Identity
+		h.strAlg = h.filters[string(strF)]
+	default:
+		return nil, fmt.Errorf("gofpdi: encryption /V %d is not supported", v)
+	}
+
+	if r >= 5 {
+		oe, _ := enc.Bytes("OE")
+		ue, _ := enc.Bytes("UE")
+		key, err := aes256FileKey(password, int(r), o, u, oe, ue)
+		if err != nil {
+			return nil, err
+		}
+		h.key = key
+		return h, nil
+	}
+
+	length := int64(40)
+	if l, ok := enc.Int("Length"); ok {
+		length = l
+	}
+	keyLen := int(length / 8)
+	if r == 2 {
+		keyLen = 5
+	}
+	if keyLen < 5 || keyLen > md5.Size {
+		return nil, fmt.Errorf("gofpdi: invalid encryption key length %d bits", length)
+	}
+	if len(o) < 32 || len(u) < 32 {
+		return nil, errors.New("gofpdi: /Encrypt /O or /U too short")
+	}
+	p, _ := enc.Int("P")
+	ps := &rc4Params{r: int(r), keyLen: keyLen, o: o[:32], u: u[:32], p: uint32(p), id0: id0, encryptMetadata: h.encryptMetadata}
+
+	pw := latin1(password)
+	if key := ps.userKey(pw); key != nil {
+		h.key = key
+		return h, nil
+	}
+	if key := ps.userKey(ps.userPasswordFromOwner(pw)); key != nil {
+		h.key = key
+		return h, nil
+	}
+	return nil, errors.New("gofpdi: incorrect password for encrypted source PDF")
+}
+
+// rc4Params carries the /Encrypt inputs of the RC4/AES-128 key derivation
+// (revisions 2 to 4).
+type rc4Params struct {
+	r, keyLen       int
+	o, u            []byte
+	p               uint32
+	id0             []byte
+	encryptMetadata bool
+}
+
+// fileKey implements algorithm 2: the file key for a user password.
+func (ps *rc4Params) fileKey(pw []byte) []byte {
+	m := md5.New()
+	m.Write(padPassword(pw))
+	m.Write(ps.o)
+	m.Write([]byte{byte(ps.p), byte(ps.p >> 8), byte(ps.p >> 16), byte(ps.p >> 24)})
+	m.Write(ps.id0)
+	if ps.r >= 4 && !ps.encryptMetadata {
+		m.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	}
+	sum := m.Sum(nil)
+	if ps.r >= 3 {
+		for i := 0; i < 50; i++ {
+			s := md5.Sum(sum[:ps.keyLen])
+			sum = s[:]
+		}
+	}
+	return sum[:ps.keyLen]
+}
+
+// userKey returns the file key if pw is the user password, nil otherwise
+// (algorithm 6).
+func (ps *rc4Params) userKey(pw []byte) []byte {
+	key := ps.fileKey(pw)
+	n := 16 // revisions 3 and 4 pad /U arbitrarily
+	if ps.r == 2 {
+		n = 32
+	}
+	if bytes.Equal(ps.userEntry(key)[:n], ps.u[:n]) {
+		return key
+	}
+	return nil
+}
+
+// userEntry computes the /U value belonging to the file key (algorithms 4
+// and 5).
+func (ps *rc4Params) userEntry(key []byte) []byte {
+	if ps.r == 2 {
+		return rc4Crypt(key, passwordPad)
+	}
+	m := md5.New()
+	m.Write(passwordPad)
+	m.Write(ps.id0)
+	u := rc4Crypt(key, m.Sum(nil))
+	for i := 1; i <= 19; i++ {
+		u = rc4Crypt(xorKey(key, byte(i)), u)
+	}
+	return append(u, make([]byte, 16)...)
+}
+
+// userPasswordFromOwner recovers the padded user password from /O under the
+// assumption that pw is the owner password (algorithm 7).
+func (ps *rc4Params) userPasswordFromOwner(pw []byte) []byte {
+	sum := md5.Sum(padPassword(pw))
+	digest := sum[:]
+	if ps.r >= 3 {
+		for i := 0; i < 50; i++ {
+			s := md5.Sum(digest)
+			digest = s[:]
+		}
+	}
+	key := digest[:ps.keyLen]
+	if ps.r == 2 {
+		return rc4Crypt(key, ps.o)
+	}
+	out := ps.o
+	for i := 19; i >= 0; i-- {
+		out = rc4Crypt(xorKey(key, byte(i)), out)
+	}
+	return out
+}
+
+// aes256FileKey validates password against the revision 5/6 /U and /O
+// entries and unwraps the file key from /UE or /OE (PDF 32000-2 §7.6.4.3.3).
+func aes256FileKey(password string, r int, o, u, oe, ue []byte) ([]byte, error) {
+	if len(o) < 48 || len(u) < 48 || len(oe) < 32 || len(ue) < 32 {
+		return nil, errors.New("gofpdi: /Encrypt AES-256 entries too short")
+	}
+	pw := []byte(password)
+	if len(pw) > 127 {
+		pw = pw[:127]
+	}
+	unwrap := func(kek, wrapped []byte) ([]byte, error) {
+		block, err := aes.NewCipher(kek)
+		if err != nil {
+			return nil, err
+		}
+		key := make([]byte, 32)
+		cipher.NewCBCDecrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(key, wrapped[:32])
+		return key, nil
+	}
+	if bytes.Equal(hashR6(pw, u[32:40], nil, r), u[:32]) {
+		return unwrap(hashR6(pw, u[40:48], nil, r), ue)
+	}
+	if bytes.Equal(hashR6(pw, o[32:40], u[:48], r), o[:32]) {
+		return unwrap(hashR6(pw, o[40:48], u[:48], r), oe)
+	}
+	return nil, errors.New("gofpdi: incorrect password for encrypted source PDF")
+}
+
+// hashR6 is the password hash of algorithm 2.B; revision 5 uses the plain
+// SHA-256 of its first round.
+func hashR6(pw, salt, udata []byte, r int) []byte {
+	h := sha256.New()
+	h.Write(pw)
+	h.Write(salt)
+	h.Write(udata)
+	k := h.Sum(nil)
+	if r == 5 {
+		return k
+	}
+	for round := 0; ; round++ {
+		k1 := bytes.Repeat(slices.Concat(pw, k, udata), 64)
+		block, _ := aes.NewCipher(k[:16])
+		e := make([]byte, len(k1))
+		cipher.NewCBCEncrypter(block, k[16:32]).CryptBlocks(e, k1)
+		mod := 0
+		for _, c := range e[:16] {
+			mod = (mod*256 + int(c)) % 3
+		}
+		switch mod {
+		case 0:
+			s := sha256.Sum256(e)
+			k = s[:]
+		case 1:
+			s := sha512.Sum384(e)
+			k = s[:]
+		default:
+			s := sha512.Sum512(e)
+			k = s[:]
+		}
+		if round >= 63 && int(e[len(e)-1]) <= round-31 {
+			return k[:32]
+		}
+	}
+}
+
+// decrypt removes the encryption layer of one string or stream belonging to
+// the indirect object ref.
+func (h *securityHandler) decrypt(data []byte, ref src.Reference, alg cryptAlg) ([]byte, error) {
+	switch alg {
+	case cryptIdentity:
+		return data, nil
+	case cryptRC4:
+		return rc4Crypt(h.objectKey(ref, false), data), nil
+	case cryptAESV2:
+		return aesDecrypt(h.objectKey(ref, true), data)
+	case cryptAESV3:
+		return aesDecrypt(h.key, data)
+	}
+	return nil, fmt.Errorf("gofpdi: unknown cipher %d", alg)
+}
+
+// objectKey derives the per-object key of algorithm 1.
+func (h *securityHandler) objectKey(ref src.Reference, aesSalt bool) []byte {
+	buf := slices.Concat(h.key, []byte{
+		byte(ref.Number), byte(ref.Number >> 8), byte(ref.Number >> 16),
+		byte(ref.Generation), byte(ref.Generation >> 8),
+	})
+	if aesSalt {
+		buf = append(buf, "sAlT"...)
+	}
+	sum := md5.Sum(buf)
+	return sum[:min(len(h.key)+5, md5.Size)]
+}
+
+// aesDecrypt decrypts AES-CBC data whose first block is the IV and strips
+// the PKCS#5 padding.
+func aesDecrypt(key, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("gofpdi: AES data is not block-aligned")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(out, data[aes.BlockSize:])
+	if pad := int(out[len(out)-1]); pad >= 1 && pad <= aes.BlockSize {
+		out = out[:len(out)-pad]
+	}
+	return out, nil
+}
+
+func rc4Crypt(key, data []byte) []byte {
+	c, _ := rc4.NewCipher(key)
+	out := make([]byte, len(data))
+	c.XORKeyStream(out, data)
+	return out
+}
+
+func xorKey(key []byte, x byte) []byte {
+	out := make([]byte, len(key))
+	for i, c := range key {
+		out[i] = c ^ x
+	}
+	return out
+}
+
+func padPassword(pw []byte) []byte {
+	return slices.Concat(pw, passwordPad)[:32]
+}
+
+// latin1 converts a password to the single-byte encoding revisions 2 to 4
+// expect; runes outside Latin-1 are dropped.
+func latin1(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r < 256 && r != utf8.RuneError {
+			out = append(out, byte(r))
+		}
+	}
+	return out
+}
+
+// decryptStream returns the raw bytes of s, the indirect object ref, without
+// their encryption layer. A leading /Crypt filter selects the cipher for this
+// stream; crypt reports it, since it is not part of the copied filter chain.
+func (h *securityHandler) decryptStream(s *src.Stream, ref src.Reference) (raw []byte, crypt bool, err error) {
+	raw, err = s.RawBytes()
+	if err != nil {
+		return nil, false, fmt.Errorf("gofpdi: read stream bytes: %w", err)
+	}
+	alg := h.stmAlg
+	if t, _ := s.Dict.Name("Type"); t == "Metadata" && !h.encryptMetadata {
+		alg = cryptIdentity
+	}
+	if filters, parms := streamFilters(s.Dict); len(filters) > 0 && filters[0] == "Crypt" {
+		name := src.Name("Identity")
+		if len(parms) > 0 {
+			if pd, ok := parms[0].(*src.Dict); ok {
+				if n, ok := pd.Name("Name"); ok {
+					name = n
+				}
+			}
+		}
+		a, ok := h.filters[string(name)]
+		if !ok {
+			return nil, false, fmt.Errorf("gofpdi: unknown crypt filter %q", name)
+		}
+		alg, crypt = a, true
+	}
+	if raw, err = h.decrypt(raw, ref, alg); err != nil {
+		return nil, false, fmt.Errorf("gofpdi: decrypt stream %d %d R: %w", ref.Number, ref.Generation, err)
+	}
+	return raw, crypt, nil
+}
+
+// sourceCrypt decrypts the strings and streams of one encrypted source. The
+// key of each depends on the indirect object it belongs to, its owner, so
+// the code walking a source carries the owner along (see resolveOwned and
+// PdfWriter.owner) and hands it over with every string and stream. A nil
+// *sourceCrypt stands for an unencrypted source and returns everything
+// unchanged.
+type sourceCrypt struct {
+	r      *src.Reader
+	h      *securityHandler
+	encRef src.Reference
+	// compressed holds the objects stored in object streams: the object
+	// stream is encrypted as a whole, not the strings inside.
+	compressed map[int]int
+	pages      []src.Reference // page objects in order, found on first use
+}
+
+// newSourceCrypt returns the decryption of r, read from buf, with h. encRef
+// is the encryption dictionary, which is not encrypted itself.
+func newSourceCrypt(r *src.Reader, h *securityHandler, encRef src.Reference, buf []byte) (*sourceCrypt, error) {
+	compressed, err := compressedObjects(buf)
+	if err != nil {
+		return nil, err
+	}
+	return &sourceCrypt{r: r, h: h, encRef: encRef, compressed: compressed}, nil
+}
+
+// resolveOwned resolves obj, a part of the object owner, in r and returns it
+// with the object it belongs to: obj itself when it is a reference.
+func resolveOwned(r *src.Reader, obj src.Object, owner src.Reference) (src.Object, src.Reference) {
+	if ref, ok := obj.(src.Reference); ok {
+		owner = ref
+	}
+	return resolveIn(r, obj), owner
+}
+
+// decryptString returns s, a string of the object owner, decrypted. A zero
+// owner is an error: the string would be copied still encrypted.
+func (c *sourceCrypt) decryptString(s []byte, owner src.Reference) ([]byte, error) {
+	if c == nil || len(s) == 0 || owner == c.encRef {
+		return s, nil
+	}
+	if _, ok := c.compressed[owner.Number]; ok {
+		return s, nil
+	}
+	if owner.Number == 0 {
+		return nil, errors.New("gofpdi: cannot decrypt a string of an unknown object")
+	}
+	plain, err := c.h.decrypt(s, owner, c.h.strAlg)
+	if err != nil {
+		return nil, fmt.Errorf("gofpdi: decrypt string in %d %d R: %w", owner.Number, owner.Generation, err)
+	}
+	return plain, nil
+}
+
+// plain is decryptString for strings that are read rather than copied: one
+// that does not decrypt is used as it is.
+func (c *sourceCrypt) plain(s []byte, owner src.Reference) []byte {
+	if p, err := c.decryptString(s, owner); err == nil {
+		return p
+	}
+	return s
+}
+
+// text returns the text string at key of d, a part of the object owner,
+// decrypted and decoded like src.Dict.String does.
+func (c *sourceCrypt) text(d *src.Dict, key string, owner src.Reference) (string, bool) {
+	if c == nil {
+		return d.String(key)
+	}
+	v, owner := resolveOwned(c.r, entry(d, key), owner)
+	b, ok := v.(src.String)
+	if !ok {
+		return "", false
+	}
+	return decodeText(c.plain(b, owner)), true
+}
+
+// streamBytes returns the raw, still filter-encoded bytes of s, the object
+// ref, for copying (see securityHandler.decryptStream).
+func (c *sourceCrypt) streamBytes(s *src.Stream, ref src.Reference) (raw []byte, crypt bool, err error) {
+	if t, _ := s.Dict.Name("Type"); c != nil && t != "XRef" {
+		if ref.Number == 0 {
+			return nil, false, errors.New("gofpdi: cannot decrypt a stream of an unknown object")
+		}
+		return c.h.decryptStream(s, ref)
+	}
+	if raw, err = s.RawBytes(); err != nil {
+		return nil, false, fmt.Errorf("gofpdi: read stream bytes: %w", err)
+	}
+	return raw, false, nil
+}
+
+// pageRef returns the object of the page with the 0-based index i.
+func (c *sourceCrypt) pageRef(i int) src.Reference {
+	if c == nil {
+		return src.Reference{}
+	}
+	if c.pages == nil {
+		c.pages = []src.Reference{}
+		if cat, ok := c.r.Trailer().Get("Root"); ok {
+			if d, ok := resolveIn(c.r, cat).(*src.Dict); ok {
+				root, _ := d.Get("Pages")
+				c.collectPages(root, make(map[src.Reference]bool), 0)
+			}
+		}
+	}
+	if i < 0 || i >= len(c.pages) {
+		return src.Reference{}
+	}
+	return c.pages[i]
+}
+
+// collectPages appends the page objects below the page tree node obj to
+// c.pages, in the order pdfdisassembler numbers them.
+func (c *sourceCrypt) collectPages(obj src.Object, seen map[src.Reference]bool, depth int) {
+	ref, ok := obj.(src.Reference)
+	node, isDict := resolveIn(c.r, obj).(*src.Dict)
+	if !ok || !isDict || seen[ref] || depth > maxPageTreeDepth {
+		return
+	}
+	seen[ref] = true
+	kids, ok := node.Array("Kids")
+	if !ok {
+		if t, _ := node.Name("Type"); t != "Pages" && !node.Has("Kids") && !node.Has("Count") {
+			c.pages = append(c.pages, ref)
+		}
+		return
+	}
+	for _, kid := range kids {
+		c.collectPages(kid, seen, depth+1)
+	}
+}
+
+// maxPageTreeDepth bounds the descent into a page tree or up its /Parent
+// chain.
+const maxPageTreeDepth = 256
+
+// inheritedOwner returns the object holding the inheritable entry key of
+// page: the value itself when it is a reference, otherwise the page or
+// /Pages node that sets it.
+func (c *sourceCrypt) inheritedOwner(page *src.Page, key string) src.Reference {
+	if c == nil {
+		return src.Reference{}
+	}
+	owner, node := c.pageRef(page.Index()), page.Dict()
+	for depth := 0; node != nil && depth <= maxPageTreeDepth; depth++ {
+		if v, ok := node.Get(key); ok {
+			if ref, ok := v.(src.Reference); ok {
+				return ref
+			}
+			return owner
+		}
+		parent, ok := node.Get("Parent")
+		if owner, ok = parent.(src.Reference); !ok {
+			break
+		}
+		node, _ = resolveIn(c.r, parent).(*src.Dict)
+	}
+	return src.Reference{}
+}
+
+// content returns the decoded content of s, the object ref.
+func (c *sourceCrypt) content(s *src.Stream, ref src.Reference) ([]byte, error) {
+	if c == nil {
+		return s.Content()
+	}
+	raw, crypt, err := c.streamBytes(s, ref)
+	if err != nil {
+		return nil, err
+	}
+	filters, parms := streamFilters(s.Dict)
+	if crypt {
+		filters = filters[1:]
+		parms = parms[min(1, len(parms)):]
+	}
+	if len(filters) == 0 {
+		return raw, nil
+	}
+	resolved := make([]src.Object, len(parms))
+	for i, p := range parms {
+		resolved[i] = resolveIn(c.r, p)
+	}
+	var entries bytes.Buffer
+	writeFilterChain(&entries, filters, resolved, writeDirect)
+	return decodeData(raw, entries.Bytes())
+}
+
+// decodeData runs raw through the filters of the stream dictionary entries
+// /Filter and /DecodeParms serialized in filters. pdfdisassembler only
+// decodes the streams of a document it has opened, so raw becomes the single
+// object of a throwaway one.
+func decodeData(raw, filters []byte) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("<<")
+	b.Write(filters)
+	fmt.Fprintf(&b, "/Length %d>>\nstream\n", len(raw))
+	b.Write(raw)
+	b.WriteString("\nendstream")
+	r, err := standalone(src.Reference{Number: 1}, b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("gofpdi: decode stream: %w", err)
+	}
+	return r.DecodeStream(src.Reference{Number: 1})
+}
+
+// standalone opens body, the object ref, as a document of its own.
+func standalone(ref src.Reference, body []byte) (*src.Reader, error) {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n")
+	off := b.Len()
+	fmt.Fprintf(&b, "%d %d obj\n", ref.Number, ref.Generation)
+	b.Write(body)
+	b.WriteString("\nendobj\n")
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 1\n0000000000 65535 f \n%d 1\n%010d %05d n \ntrailer\n<</Size %d>>\nstartxref\n%d\n%%%%EOF\n",
+		ref.Number, off, ref.Generation, ref.Number+1, xref)
+	return src.Open(bytes.NewReader(b.Bytes()))
+}
+
+var objHeader = regexp.MustCompile(`^\s*(\d+)\s+(\d+)\s+obj`)
+
+// compressedObjects returns the objects stored in object streams, mapped to
+// the number of their object stream, as the cross-reference streams of buf
+// list them. Only the cross-reference sections are read, each on its own;
+// no object is resolved.
+func compressedObjects(buf []byte) (map[int]int, error) {
+	start, err := lastStartXref(buf)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[int]int)
+	seen := make(map[int64]bool)
+	for todo := []int64{start}; len(todo) > 0; {
+		off := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if seen[off] || off < 0 || off >= int64(len(buf)) {
+			continue
+		}
+		seen[off] = true
+		sec := buf[off:]
+		if bytes.HasPrefix(bytes.TrimLeft(sec, " \t\r\n"), []byte("xref")) {
+			// A table lists no compressed objects, but its trailer may
+			// point at a cross-reference stream (/XRefStm) as well as the
+			// previous section.
+			i := bytes.Index(sec, []byte("trailer"))
+			if i < 0 {
+				continue
+			}
+			trailer := sec[i:]
+			if j := bytes.Index(trailer, []byte("startxref")); j >= 0 {
+				trailer = trailer[:j]
+			}
+			for _, re := range []*regexp.Regexp{xrefStmEntry, prevEntry} {
+				if m := re.FindSubmatch(trailer); m != nil {
+					n, _ := strconv.ParseInt(string(m[1]), 10, 64)
+					todo = append(todo, n)
+				}
+			}
+			continue
+		}
+		m := objHeader.FindSubmatchIndex(sec)
+		end := bytes.Index(sec, []byte("endstream"))
+		if m == nil || end < m[1] {
+			return nil, fmt.Errorf("gofpdi: no cross-reference section at offset %d", off)
+		}
+		num, _ := strconv.Atoi(string(sec[m[2]:m[3]]))
+		gen, _ := strconv.Atoi(string(sec[m[4]:m[5]]))
+		ref := src.Reference{Number: num, Generation: gen}
+		r, err := standalone(ref, sec[m[1]:end+len("endstream")])
+		if err != nil {
+			return nil, fmt.Errorf("gofpdi: cross-reference stream %d %d R: %w", num, gen, err)
+		}
+		xs, ok := resolveIn(r, ref).(*src.Stream)
+		if !ok {
+			return nil, fmt.Errorf("gofpdi: cross-reference stream %d %d R is not a stream", num, gen)
+		}
+		if err := xrefStreamEntries(r, xs, out); err != nil {
+			return nil, fmt.Errorf("gofpdi: cross-reference stream %d %d R: %w", num, gen, err)
+		}
+		if prev, ok := xs.Dict.Int("Prev"); ok {
+			todo = append(todo, prev)
+		}
+	}
+	return out, nil
+}
+
+// xrefStreamEntries adds the compressed objects the cross-reference stream
+// s of r lists to out.
+func xrefStreamEntries(r *src.Reader, s *src.Stream, out map[int]int) error {
+	data, err := s.Content()
+	if err != nil {
+		return err
+	}
+	w, _ := numbersIn(r, entry(s.Dict, "W"))
+	if len(w) != 3 {
+		return errors.New("bad /W")
+	}
+	var widths [3]int
+	for i, f := range w {
+		if f < 0 || f > 8 {
+			return errors.New("bad /W")
+		}
+		widths[i] = int(f)
+	}
+	index, _ := numbersIn(r, entry(s.Dict, "Index"))
+	if index == nil {
+		size, _ := s.Dict.Int("Size")
+		index = []float64{0, float64(size)}
+	}
+	field := func(b []byte, typ bool) int {
+		if len(b) == 0 && typ {
+			return 1 // the type defaults to in-file objects
+		}
+		n := 0
+		for _, c := range b {
+			n = n<<8 | int(c)
+		}
+		return n
+	}
+	rowLen := widths[0] + widths[1] + widths[2]
+	for i := 0; i+1 < len(index); i += 2 {
+		for n := range int(index[i+1]) {
+			if len(data) < rowLen {
+				return nil
+			}
+			row := data[:rowLen]
+			data = data[rowLen:]
+			if field(row[:widths[0]], true) == 2 {
+				out[int(index[i])+n] = field(row[widths[0]:widths[0]+widths[1]], false)
+			}
+		}
+	}
+	return nil
+}
+
+// openSource opens a source PDF and, when it is encrypted, sets up its
+// decryption. password is only needed when the user password is not empty;
+// either the user or the owner password is accepted then.
+func openSource(rs io.ReadSeeker, password string) (*src.Reader, *sourceCrypt, error) {
+	r, err := src.Open(rs)
+	if err == nil {
+		encObj, ok := r.Trailer().Get("Encrypt")
+		if !ok {
+			return r, nil, nil
+		}
+		// pdfdisassembler opened it, so the user password is empty.
+		enc, err := r.ResolveDict(encObj)
+		if err != nil {
+			return nil, nil, fmt.Errorf("gofpdi: /Encrypt: %w", err)
+		}
+		h, err := newSecurityHandler(enc, trailerID0(r), "")
+		if err != nil {
+			return nil, nil, err
+		}
+		encRef, _ := encObj.(src.Reference)
+		buf, err := readAll(rs)
+		if err != nil {
+			return nil, nil, fmt.Errorf("gofpdi: open source PDF: %w", err)
+		}
+		c, err := newSourceCrypt(r, h, encRef, buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r, c, nil
+	}
+	buf, rerr := readAll(rs)
+	if rerr != nil {
+		return nil, nil, fmt.Errorf("gofpdi: open source PDF: %w", err)
+	}
+	encRef, ok := trailerEncryptRef(buf)
+	if !ok {
+		return nil, nil, fmt.Errorf("gofpdi: open source PDF: %w", err)
+	}
+	return openWithPassword(buf, encRef, password)
+}
+
+// openWithPassword opens buf, whose encryption dictionary encRef needs a
+// password, through an update appended in memory (see the comment at the top
+// of this file).
+func openWithPassword(buf []byte, encRef src.Reference, password string) (*src.Reader, *sourceCrypt, error) {
+	prev, err := lastStartXref(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	// A first pass, with an encryption dictionary that opens but decrypts
+	// nothing correctly, reads the real one and the object streams.
+	boot, err := src.Open(bytes.NewReader(appendUpdate(buf, prev, nil, bootstrapEncrypt())))
+	if err != nil {
+		return nil, nil, fmt.Errorf("gofpdi: open source PDF: %w", err)
+	}
+	enc, err := boot.ResolveDict(encRef)
+	if err != nil {
+		return nil, nil, fmt.Errorf("gofpdi: /Encrypt: %w", err)
+	}
+	id0 := trailerID0(boot)
+	h, err := newSecurityHandler(enc, id0, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	compressed, err := compressedObjects(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	streams := make(map[int]bool)
+	for _, n := range compressed {
+		streams[n] = true
+	}
+	var objStms []updateObject
+	for _, n := range slices.Sorted(maps.Keys(streams)) {
+		ref := src.Reference{Number: n}
+		s, ok := resolveIn(boot, ref).(*src.Stream)
+		if !ok {
+			continue
+		}
+		raw, crypt, err := h.decryptStream(s, ref)
+		if err != nil {
+			return nil, nil, err
+		}
+		var b bytes.Buffer
+		b.WriteString("<<")
+		for k, v := range s.Dict.Iter() {
+			if k == "Length" || crypt && (k == "Filter" || k == "DecodeParms") {
+				continue
+			}
+			b.WriteString("/" + escapeName(k) + " ")
+			writeDirect(&b, v)
+		}
+		if crypt {
+			filters, parms := streamFilters(s.Dict)
+			writeFilterChain(&b, filters[1:], parms[min(1, len(parms)):], writeDirect)
+		}
+		fmt.Fprintf(&b, "/Length %d>>\nstream\n", len(raw))
+		b.Write(raw)
+		b.WriteString("\nendstream")
+		objStms = append(objStms, updateObject{ref: ref, body: b.Bytes()})
+	}
+
+	r, err := src.Open(bytes.NewReader(appendUpdate(buf, prev, objStms, identityEncrypt(id0))))
+	if err != nil {
+		return nil, nil, fmt.Errorf("gofpdi: open source PDF: %w", err)
+	}
+	return r, &sourceCrypt{r: r, h: h, encRef: encRef, compressed: compressed}, nil
+}
+
+// updateObject is an object of an update appended to a source.
+type updateObject struct {
+	ref  src.Reference
+	body []byte
+}
+
+// appendUpdate returns buf followed by an incremental update holding objs,
+// whose trailer, after the previous cross-reference section at prev, has the
+// extra entries in trailer.
+func appendUpdate(buf []byte, prev int64, objs []updateObject, trailer string) []byte {
+	var b bytes.Buffer
+	b.Write(buf)
+	b.WriteByte('\n')
+	offsets := make([]int, len(objs))
+	for i, o := range objs {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d %d obj\n%s\nendobj\n", o.ref.Number, o.ref.Generation, o.body)
+	}
+	xref := b.Len()
+	b.WriteString("xref\n")
+	for i, o := range objs {
+		fmt.Fprintf(&b, "%d 1\n%010d %05d n \n", o.ref.Number, offsets[i], o.ref.Generation)
+	}
+	fmt.Fprintf(&b, "trailer\n<<%s /Prev %d>>\nstartxref\n%d\n%%%%EOF\n", trailer, prev, xref)
+	return b.Bytes()
+}
+
+// bootstrapEncrypt returns an AES-256 /Encrypt entry whose /U accepts the
+// empty password. The file key it yields is meaningless.
+func bootstrapEncrypt() string {
+	salts := make([]byte, 16) // validation and key salt
+	u := append(hashR6(nil, salts[:8], nil, 5), salts...)
+	return fmt.Sprintf("/Encrypt <</Filter /Standard /V 5 /R 5 /Length 256 /P -4 /O <%x> /U <%x> /OE <%x> /UE <%x>>>",
+		make([]byte, 48), u, make([]byte, 32), make([]byte, 32))
+}
+
+// identityEncrypt returns a revision 4 /Encrypt entry with identity crypt
+// filters whose /U accepts the empty password, for a file whose /ID starts
+// with id0.
+func identityEncrypt(id0 []byte) string {
+	ps := &rc4Params{r: 4, keyLen: 16, o: passwordPad, p: 0xfffffffc, id0: id0, encryptMetadata: true}
+	return fmt.Sprintf("/Encrypt <</Filter /Standard /V 4 /R 4 /Length 128 /P -4 /O <%x> /U <%x> /StmF /Identity /StrF /Identity>>",
+		ps.o, ps.userEntry(ps.fileKey(nil)))
+}
+
+// trailerID0 returns the first element of the trailer /ID of r.
+func trailerID0(r *src.Reader) []byte {
+	if id, ok := r.Trailer().Array("ID"); ok && len(id) > 0 {
+		if s, ok := id[0].(src.String); ok {
+			return s
+		}
+	}
+	return nil
+}
+
+var (
+	encryptEntry = regexp.MustCompile(`/Encrypt\s+(\d+)\s+(\d+)\s+R`)
+	prevEntry    = regexp.MustCompile(`/Prev\s+(\d+)`)
+	xrefStmEntry = regexp.MustCompile(`/XRefStm\s+(\d+)`)
+)
+
+// trailerEncryptRef returns the /Encrypt reference of the newest trailer
+// that has one. Trailer dictionaries and cross-reference stream dictionaries
+// are never encrypted, so they are searched as text.
+func trailerEncryptRef(buf []byte) (src.Reference, bool) {
+	off, err := lastStartXref(buf)
+	for seen := make(map[int64]bool); err == nil && !seen[off] && off >= 0 && off < int64(len(buf)); {
+		seen[off] = true
+		dict := buf[off:]
+		if bytes.HasPrefix(bytes.TrimLeft(dict, " \t\r\n"), []byte("xref")) {
+			i := bytes.Index(dict, []byte("trailer"))
+			if i < 0 {
+				break
+			}
+			dict = dict[i:]
+		}
+		for _, end := range []string{"stream", "startxref"} {
+			if i := bytes.Index(dict, []byte(end)); i >= 0 {
+				dict = dict[:i]
+			}
+		}
+		if m := encryptEntry.FindSubmatch(dict); m != nil {
+			num, _ := strconv.Atoi(string(m[1]))
+			gen, _ := strconv.Atoi(string(m[2]))
+			return src.Reference{Number: num, Generation: gen}, true
+		}
+		m := prevEntry.FindSubmatch(dict)
+		if m == nil {
+			break
+		}
+		off, err = strconv.ParseInt(string(m[1]), 10, 64)
+	}
+	return src.Reference{}, false
+}
+
+// lastStartXref returns the offset named by the final startxref keyword.
+func lastStartXref(buf []byte) (int64, error) {
+	i := bytes.LastIndex(buf, []byte("startxref"))
+	if i < 0 {
+		return 0, errors.New("gofpdi: startxref not found")
+	}
+	f := bytes.Fields(buf[i+len("startxref"):])
+	if len(f) == 0 {
+		return 0, errors.New("gofpdi: startxref offset missing")
+	}
+	return strconv.ParseInt(string(f[0]), 10, 64)
+}
+
+// writeDirect serializes obj with its references left as they are, for the
+// objects gofpdi hands back to pdfdisassembler.
+func writeDirect(b *bytes.Buffer, obj src.Object) {
+	switch o := obj.(type) {
+	case src.Reference:
+		fmt.Fprintf(b, "%d %d R ", o.Number, o.Generation)
+	case src.Array:
+		b.WriteByte('[')
+		for _, e := range o {
+			writeDirect(b, e)
+		}
+		b.WriteByte(']')
+	case *src.Dict:
+		b.WriteString("<<")
+		for k, v := range o.Iter() {
+			b.WriteString("/" + escapeName(k) + " ")
+			writeDirect(b, v)
+		}
+		b.WriteString(">>")
+	case nil:
+		b.WriteString("null ")
+	default:
+		(&PdfWriter{currentObj: b}).writeObject(obj)
+	}
+}
+
+// writeFilterChain writes the /Filter and /DecodeParms entries for filters
+// and parms, using write for the parameter dictionaries.
+func writeFilterChain(b *bytes.Buffer, filters []string, parms []src.Object, write func(*bytes.Buffer, src.Object)) {
+	if len(filters) == 0 {
+		return
+	}
+	b.WriteString("/Filter [")
+	for _, f := range filters {
+		b.WriteString("/" + escapeName(f) + " ")
+	}
+	b.WriteString("]")
+	if len(parms) > 0 {
+		b.WriteString("/DecodeParms ")
+		write(b, src.Array(parms))
+	}
+}
+
+// streamFilters returns the stream's filter names and their decode
+// parameters (one entry per filter, possibly Null).
+func streamFilters(d *src.Dict) ([]string, []src.Object) {
+	var names []string
+	switch f, _ := d.Get("Filter"); f := f.(type) {
+	case src.Name:
+		names = []string{string(f)}
+	case src.Array:
+		for _, e := range f {
+			if n, ok := e.(src.Name); ok {
+				names = append(names, string(n))
+			}
+		}
+	}
+	var parms []src.Object
+	switch p, _ := d.Get("DecodeParms"); p := p.(type) {
+	case src.Array:
+		parms = p
+	case nil:
+	default:
+		parms = []src.Object{p}
+	}
+	return names, parms
+}
+
+// decodeText decodes a PDF text string (PDF 32000-1 §7.9.2.2): UTF-16BE or
+// UTF-8 with a byte order mark, PDFDocEncoding otherwise.
+func decodeText(b []byte) string {
+	switch {
+	case len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff:
+		u := make([]uint16, (len(b)-2)/2)
+		for i := range u {
+			u[i] = uint16(b[2+2*i])<<8 | uint16(b[3+2*i])
+		}
+		return string(utf16.Decode(u))
+	case len(b) >= 3 && b[0] == 0xef && b[1] == 0xbb && b[2] == 0xbf:
+		return string(b[3:])
+	}
+	out := make([]rune, len(b))
+	for i, c := range b {
+		if r, ok := pdfDocSpecial[c]; ok {
+			out[i] = r
+		} else {
+			out[i] = rune(c)
+		}
+	}
+	return string(out)
+}
+
+// pdfDocSpecial maps the PDFDocEncoding codes that differ from Latin-1
+// (PDF 32000-1 Annex D.2).
+var pdfDocSpecial = map[byte]rune{
+	0x18: '˘', 0x19: 'ˇ', 0x1a: 'ˆ', 0x1b: '˙', 0x1c: '˝', 0x1d: '˛', 0x1e: '˚', 0x1f: '˜',
+	0x80: '•', 0x81: '†', 0x82: '‡', 0x83: '…', 0x84: '—', 0x85: '–', 0x86: 'ƒ', 0x87: '⁄',
+	0x88: '‹', 0x89: '›', 0x8a: '−', 0x8b: '‰', 0x8c: '„', 0x8d: '“', 0x8e: '”', 0x8f: '‘',
+	0x90: '’', 0x91: '‚', 0x92: '™', 0x93: 'ﬁ', 0x94: 'ﬂ', 0x95: 'Ł', 0x96: 'Œ', 0x97: 'Š',
+	0x98: 'Ÿ', 0x99: 'Ž', 0x9a: 'ı', 0x9b: 'ł', 0x9c: 'œ', 0x9d: 'š', 0x9e: 'ž', 0xa0: '€',
+}
@@ -0,0 +1,266 @@
+package gofpdi
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	src "github.com/speedata/pdfdisassembler"
+)
+
+// testEncryption builds the /Encrypt dictionary of a fixture and encrypts
+// its strings and streams, mirroring what a producer does.
+type testEncryption struct {
+	dict    string
+	encrypt func(data []byte, ref src.Reference) []byte
+}
+
+var testID0 = []byte("0123456789abcdef")
+
+func aesEncrypt(key, data []byte) []byte {
+	pad := aes.BlockSize - len(data)%aes.BlockSize
+	plain := append(bytes.Clone(data), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	iv := []byte("fixed-test-iv-16")
+	block, _ := aes.NewCipher(key)
+	out := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, plain)
+	return append(iv, out...)
+}
+
+// rc4Encryption secures a fixture with revision 3 (RC4) or revision 4
+// (AES-128 crypt filters).
+func rc4Encryption(user, owner string, aesV2 bool) testEncryption {
+	const keyLen = 16
+	r := 3
+	if aesV2 {
+		r = 4
+	}
+	ownerSum := md5.Sum(padPassword([]byte(owner)))
+	ownerKey := ownerSum[:]
+	for i := 0; i < 50; i++ {
+		s := md5.Sum(ownerKey)
+		ownerKey = s[:]
+	}
+	o := padPassword([]byte(user))
+	for i := 0; i <= 19; i++ {
+		o = rc4Crypt(xorKey(ownerKey[:keyLen], byte(i)), o)
+	}
+	ps := &rc4Params{r: r, keyLen: keyLen, o: o, p: 0xfffff0c4, id0: testID0, encryptMetadata: true}
+	key := ps.fileKey([]byte(user))
+	m := md5.New()
+	m.Write(passwordPad)
+	m.Write(testID0)
+	u := rc4Crypt(key, m.Sum(nil))
+	for i := 1; i <= 19; i++ {
+		u = rc4Crypt(xorKey(key, byte(i)), u)
+	}
+	u = append(u, make([]byte, 16)...)
+
+	h := &securityHandler{key: key}
+	dict := fmt.Sprintf("<</Filter /Standard /V 2 /R 3 /Length 128 /P -3900 /O <%x> /U <%x>>>", o, u)
+	enc := func(data []byte, ref src.Reference) []byte { return rc4Crypt(h.objectKey(ref, false), data) }
+	if aesV2 {
+		dict = fmt.Sprintf("<</Filter /Standard /V 4 /R 4 /Length 128 /P -3900 /O <%x> /U <%x>"+
+			" /CF <</StdCF <</CFM /AESV2 /Length 16>>>> /StmF /StdCF /StrF /StdCF>>", o, u)
+		enc = func(data []byte, ref src.Reference) []byte { return aesEncrypt(h.objectKey(ref, true), data) }
+	}
+	return testEncryption{dict: dict, encrypt: enc}
+}
+
+// aes256Encryption secures a fixture with revision 6 (AES-256).
+func aes256Encryption(user, owner string) testEncryption {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	wrap := func(kek []byte) []byte {
+		block, _ := aes.NewCipher(kek)
+		out := make([]byte, 32)
+		cipher.NewCBCEncrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(out, key)
+		return out
+	}
+	uvs, uks, ovs, oks := []byte("uvsuvsuv"), []byte("uksuksuk"), []byte("ovsovsov"), []byte("oksoksok")
+	u := append(append(hashR6([]byte(user), uvs, nil, 6), uvs...), uks...)
+	ue := wrap(hashR6([]byte(user), uks, nil, 6))
+	o := append(append(hashR6([]byte(owner), ovs, u, 6), ovs...), oks...)
+	oe := wrap(hashR6([]byte(owner), oks, u, 6))
+	dict := fmt.Sprintf("<</Filter /Standard /V 5 /R 6 /Length 256 /P -3900 /O <%x> /U <%x> /OE <%x> /UE <%x>"+
+		" /CF <</StdCF <</CFM /AESV3 /Length 32>>>> /StmF /StdCF /StrF /StdCF>>", o, u, oe, ue)
+	return testEncryption{dict: dict, encrypt: func(data []byte, _ src.Reference) []byte { return aesEncrypt(key, data) }}
+}
+
+// buildEncryptedPDF returns a one-page PDF whose content stream, the string
+// in its optional content group and a string held directly in the page's
+// resources are encrypted with enc. With objStm
+// the catalog, page tree, page and group sit in an encrypted object stream
+// and a cross-reference stream replaces the xref table.
+func buildEncryptedPDF(t *testing.T, enc testEncryption, objStm bool) []byte {
+	t.Helper()
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write([]byte("BT /F1 12 Tf (Hello) Tj ET"))
+	zw.Close()
+	content := enc.encrypt(z.Bytes(), src.Reference{Number: 4})
+	layer := fmt.Sprintf("<%x>", enc.encrypt([]byte("Secret Layer"), src.Reference{Number: 5}))
+	alt := fmt.Sprintf("<%x>", enc.encrypt([]byte("Page Alt"), src.Reference{Number: 3}))
+	if objStm {
+		// Objects in object streams are not encrypted on their own.
+		layer, alt = "(Secret Layer)", "(Page Alt)"
+	}
+
+	objs := []string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 200] /Resources <</Properties <</L0 5 0 R /P0 <</Alt " + alt + ">>>>>> /Contents 4 0 R>>",
+		fmt.Sprintf("<</Filter /FlateDecode /Length %d>>\nstream\n%s\nendstream", len(content), content),
+		"<</Type /OCG /Name " + layer + ">>",
+		enc.dict,
+	}
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+	if !objStm {
+		offsets := make([]int, len(objs))
+		for i, body := range objs {
+			offsets[i] = buf.Len()
+			fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, body)
+		}
+		xref := buf.Len()
+		fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+		for _, off := range offsets {
+			fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+		}
+		fmt.Fprintf(&buf, "trailer\n<</Size %d /Root 1 0 R /Encrypt 6 0 R /ID [<%x> <%x>]>>\nstartxref\n%d\n%%%%EOF\n",
+			len(objs)+1, testID0, testID0, xref)
+		return buf.Bytes()
+	}
+
+	// Objects 1, 2, 3 and 5 go into object stream 7, the XRef stream is 8.
+	var header, body bytes.Buffer
+	index := make(map[int]int)
+	for i, n := range []int{1, 2, 3, 5} {
+		fmt.Fprintf(&header, "%d %d ", n, body.Len())
+		body.WriteString(objs[n-1] + "\n")
+		index[n] = i
+	}
+	stm := enc.encrypt(append(header.Bytes(), body.Bytes()...), src.Reference{Number: 7})
+	offsets := make(map[int]int)
+	for _, n := range []int{4, 6} {
+		offsets[n] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", n, objs[n-1])
+	}
+	offsets[7] = buf.Len()
+	fmt.Fprintf(&buf, "7 0 obj\n<</Type /ObjStm /N 4 /First %d /Length %d>>\nstream\n%s\nendstream\nendobj\n", header.Len(), len(stm), stm)
+	offsets[8] = buf.Len()
+	var xref bytes.Buffer
+	xref.Write([]byte{0, 0, 0, 0, 0, 0xff, 0xff})
+	for n := 1; n <= 8; n++ {
+		if i, ok := index[n]; ok {
+			xref.Write([]byte{2, 0, 0, 0, 7, 0, byte(i)})
+		} else {
+			off := offsets[n]
+			xref.Write([]byte{1, byte(off >> 24), byte(off >> 16), byte(off >> 8), byte(off), 0, 0})
+		}
+	}
+	fmt.Fprintf(&buf, "8 0 obj\n<</Type /XRef /Size 9 /W [1 4 2] /Root 1 0 R /Encrypt 6 0 R /ID [<%x> <%x>] /Length %d>>\nstream\n",
+		testID0, testID0, xref.Len())
+	buf.Write(xref.Bytes())
+	fmt.Fprintf(&buf, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", offsets[8])
+	return buf.Bytes()
+}
+
+func TestImportEncrypted(t *testing.T) {
+	for _, user := range []string{"user", ""} {
+		fixtures := map[string]testEncryption{
+			"rc4":    rc4Encryption(user, "owner", false),
+			"aes128": rc4Encryption(user, "owner", true),
+			"aes256": aes256Encryption(user, "owner"),
+		}
+		for name, enc := range fixtures {
+			for _, objStm := range []bool{false, true} {
+				name := name
+				if user == "" {
+					name += "/nouser"
+				}
+				if objStm {
+					name += "/objstm"
+				}
+				pdf := buildEncryptedPDF(t, enc, objStm)
+				for _, pw := range []string{user, "owner"} {
+					t.Run(name+"/"+pw, func(t *testing.T) {
+						testImportEncrypted(t, pdf, pw)
+					})
+				}
+				if user == "" {
+					continue
+				}
+				t.Run(name+"/wrong", func(t *testing.T) {
+					imp := NewImporter()
+					imp.SetSourcePassword("wrong")
+					if err := imp.SetSourceStream(bytes.NewReader(pdf)); err == nil {
+						t.Error("SetSourceStream with a wrong password should fail")
+					}
+				})
+			}
+		}
+	}
+}
+
+// testImportEncrypted imports the page of a buildEncryptedPDF fixture with
+// the password pw and checks that its content, resources and layer name come
+// out decrypted.
+func testImportEncrypted(t *testing.T, pdf []byte, pw string) {
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	imp.SetSourcePassword(pw)
+	if err := imp.SetSourceStream(bytes.NewReader(pdf)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := imp.ImportPage(1, "/MediaBox"); err != nil {
+		t.Fatal(err)
+	}
+	names, err := imp.PutFormXobjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs := imp.GetImportedObjects()
+
+	form := objs[names["/GOFPDITPL0"]]
+	_, body, _ := bytes.Cut(form, []byte("stream\n"))
+	zr, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := io.ReadAll(zr)
+	if !bytes.Contains(plain, []byte("(Hello) Tj")) {
+		t.Errorf("template content not decrypted: %q", plain)
+	}
+	if !bytes.Contains(form, []byte("/Alt (Page Alt)")) {
+		t.Errorf("string in the page resources not decrypted: %q", form)
+	}
+	found := false
+	for _, b := range objs {
+		found = found || strings.Contains(string(b), "(Secret Layer)")
+	}
+	if !found {
+		t.Error("copied OCG /Name is not plaintext")
+	}
+	if l, err := imp.GetLayers(0); err != nil || len(l.OCGs) != 1 || l.OCGs[0].Name != "Secret Layer" {
+		t.Errorf("layers = %+v, %v", l, err)
+	}
+}
+
+func TestImportEncryptedUnsupported(t *testing.T) {
+	enc := rc4Encryption("", "owner", false)
+	enc.dict = "<</Filter /Adobe.PubSec /V 4 /R 4 /Length 128>>"
+	for _, objStm := range []bool{false, true} {
+		imp := NewImporter()
+		if err := imp.SetSourceStream(bytes.NewReader(buildEncryptedPDF(t, enc, objStm))); err == nil {
+			t.Errorf("objStm=%v: source with an unsupported security handler opened", objStm)
+		} else if strings.Count(err.Error(), "gofpdi:") != 1 {
+			t.Errorf("objStm=%v: error %q", objStm, err)
+		}
+	}
+}
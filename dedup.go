@@ -0,0 +1,121 @@
+package gofpdi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	src "github.com/speedata/pdfdisassembler"
+)
+
+// Content-hash deduplication (opt-in, see Importer.SetDeduplicate) extends
+// refMap, which only recognizes the same source Reference, to objects whose
+// content is identical: the same embedded font or ICC profile coming from two
+// files, or from two objects of one file, is copied once.
+//
+// An output number is handed out, and written into the referring object, the
+// moment a reference is first seen, so the decision cannot wait until drain
+// has serialized the object. assignRef therefore computes a digest of the
+// serialized body up front — dictionary plus raw stream bytes — in which every
+// reference is replaced by the digest of its target. Two objects with equal
+// digests have equal bodies all the way down and can share one output object.
+
+// objDigest is the memoized content digest of one source object.
+type objDigest struct {
+	sum  [sha256.Size]byte
+	size int         // serialized body size in bytes
+	refs []sourceRef // references in the body, for the savings statistics
+}
+
+// DedupStats reports what content-hash deduplication saved.
+type DedupStats struct {
+	// Objects is the number of source objects that were not copied because
+	// an identical object had already been emitted.
+	Objects int
+	// Bytes is the combined serialized size of those objects.
+	Bytes int64
+}
+
+// digest returns the content digest of key, computing it on first use. An
+// object that is reached again while its own digest is being computed (a
+// reference cycle such as /Parent links) contributes its source identity
+// instead, which keeps the digest sound: such objects only ever match
+// themselves.
+func (pw *PdfWriter) digest(key sourceRef) objDigest {
+	if d, ok := pw.digests[key]; ok {
+		return d
+	}
+	identity := objDigest{sum: sha256.Sum256(fmt.Appendf(nil, "%d:%d:%d", key.source, key.ref.Number, key.ref.Generation))}
+	if pw.digesting[key] {
+		return identity
+	}
+	obj, err := pw.sources[key.source].Resolve(key.ref)
+	if err != nil {
+		pw.digests[key] = identity // drain reports the error when it copies the object
+		return identity
+	}
+	if d, ok := obj.(*src.Dict); ok {
+		if t, _ := d.Name("Type"); t == "OCG" {
+			// Equal layer dictionaries are still distinct layers.
+			pw.digests[key] = identity
+			return identity
+		}
+	}
+
+	pw.digesting[key] = true
+	savedObj, savedRefs, savedSource, savedOwner := pw.currentObj, pw.digestRefs, pw.source, pw.owner
+	pw.currentObj, pw.digestRefs, pw.source, pw.owner = new(bytes.Buffer), nil, key.source, key.ref
+	pw.writeObject(obj)
+	d := objDigest{
+		sum:  sha256.Sum256(pw.currentObj.Bytes()),
+		size: pw.currentObj.Len(),
+		refs: pw.digestRefs,
+	}
+	pw.currentObj, pw.digestRefs, pw.source, pw.owner = savedObj, savedRefs, savedSource, savedOwner
+	delete(pw.digesting, key)
+
+	pw.digests[key] = d
+	return d
+}
+
+// writeDigestRef writes the digest of a referenced object in place of an
+// output reference while a digest is being computed.
+func (pw *PdfWriter) writeDigestRef(key sourceRef) {
+	d := pw.digest(key)
+	pw.digestRefs = append(pw.digestRefs, key)
+	fmt.Fprintf(pw.currentObj, "<%x> ", d.sum)
+}
+
+// dedupRef returns the output number of an already emitted object with the
+// same content as key, or ok=false (after remembering key's digest for later
+// lookups under id) when there is none yet. id is the number reserved for key
+// if it turns out to be new.
+func (pw *PdfWriter) dedupRef(key sourceRef) (int, bool) {
+	d := pw.digest(key)
+	id, ok := pw.hashMap[d.sum]
+	if !ok {
+		return 0, false
+	}
+	// Everything reachable from key is an identical copy of what was emitted
+	// under id: map it to the existing numbers as well so it is counted once
+	// and never copied.
+	pw.recordSaved(key, id)
+	return id, true
+}
+
+// recordSaved maps key, and recursively the objects it references, to the
+// output numbers of their emitted twins and adds them to the statistics.
+func (pw *PdfWriter) recordSaved(key sourceRef, id int) {
+	if _, ok := pw.refMap[key]; ok {
+		return
+	}
+	d := pw.digests[key]
+	pw.refMap[key] = id
+	pw.dedupStats.Objects++
+	pw.dedupStats.Bytes += int64(d.size)
+	for _, child := range d.refs {
+		if childID, ok := pw.hashMap[pw.digests[child].sum]; ok {
+			pw.recordSaved(child, childID)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package gofpdi
+
+import (
+	"math"
+
+	src "github.com/speedata/pdfdisassembler"
+)
+
+// Destination is a view of an imported page (PDF 32000-1 §12.3.2.2) with its
+// coordinates in the form space of the template, the coordinate system the
+// host draws the template in. Coordinates a destination type does not use,
+// and those left null in the source ("keep the current value"), are NaN.
+type Destination struct {
+	// Template is the template index of the target page.
+	Template int
+	// Fit is the destination type: "XYZ", "Fit", "FitH", "FitV", "FitR",
+	// "FitB", "FitBH" or "FitBV".
+	Fit string
+	// Left, Bottom, Right and Top are the coordinates of the destination.
+	Left, Bottom, Right, Top float64
+	// Zoom is the magnification of an XYZ destination; 0 and NaN keep the
+	// current zoom.
+	Zoom float64
+}
+
+// ResolvedDest is a destination of a source PDF resolved to a page.
+type ResolvedDest struct {
+	// Source is the source handle and Page the 1-based page number there.
+	Source, Page int
+	// Destination is the view of the page. When the page has been imported,
+	// Template is its first template and the coordinates are in form space;
+	// otherwise Template is -1 and the coordinates are in the default user
+	// space of the source page.
+	Destination
+}
+
+// sourceDest is a destination into a source PDF, before it is mapped to a
+// template.
+type sourceDest struct {
+	page   int       // 1-based page number
+	fit    string    // destination type
+	params []float64 // the numbers following the type; NaN for null
+}
+
+// maxDestDepth bounds the indirections (named destinations, name tree
+// levels) followed while resolving a destination.
+const maxDestDepth = 32
+
+// pageNumbers maps the page dictionaries of r to their 1-based page numbers.
+// Dictionaries are cached by the reader, so a resolved page reference yields
+// the same pointer.
+func pageNumbers(r *src.Reader) map[*src.Dict]int {
+	pages, err := r.Pages()
+	if err != nil {
+		return nil
+	}
+	out := make(map[*src.Dict]int, len(pages))
+	for _, p := range pages {
+		out[p.Dict()] = p.Index() + 1
+	}
+	return out
+}
+
+// parseDest resolves dest (an explicit destination array, a named
+// destination given as a name or string, or a dictionary with /D), a part
+// of the object owner, into a destination in r. ok is false when it does not
+// lead to a page of r. c decrypts the names of an encrypted r; a string with
+// a zero owner is taken as plain text.
+func parseDest(r *src.Reader, c *sourceCrypt, pages map[*src.Dict]int, dest src.Object, owner src.Reference) (sourceDest, bool) {
+	for depth := 0; depth < maxDestDepth; depth++ {
+		var d src.Object
+		switch d, owner = resolveOwned(r, dest, owner); d := d.(type) {
+		case src.Array:
+			return parseExplicitDest(r, pages, d)
+		case src.Name:
+			dest, owner = namedDest(r, c, string(d), false)
+		case src.String:
+			dest, owner = namedDest(r, c, string(c.plain(d, owner)), true)
+		case *src.Dict:
+			dest, _ = d.Get("D")
+		default:
+			return sourceDest{}, false
+		}
+	}
+	return sourceDest{}, false
+}
+
+// parseExplicitDest parses [page /Type params…].
+func parseExplicitDest(r *src.Reader, pages map[*src.Dict]int, arr src.Array) (sourceDest, bool) {
+	if len(arr) < 2 {
+		return sourceDest{}, false
+	}
+	page, ok := resolveIn(r, arr[0]).(*src.Dict)
+	if !ok {
+		return sourceDest{}, false // remote destinations carry a page index
+	}
+	num, ok := pages[page]
+	if !ok {
+		return sourceDest{}, false
+	}
+	fit, ok := resolveIn(r, arr[1]).(src.Name)
+	if !ok {
+		return sourceDest{}, false
+	}
+	sd := sourceDest{page: num, fit: string(fit)}
+	for _, p := range arr[2:] {
+		f, ok := number(resolveIn(r, p))
+		if !ok {
+			f = math.NaN()
+		}
+		sd.params = append(sd.params, f)
+	}
+	return sd, true
+}
+
+// namedDest looks a named destination up: names in the catalog's /Dests
+// dictionary (PDF 1.1), strings in the /Names /Dests name tree. It returns
+// the destination with the object it is part of.
+func namedDest(r *src.Reader, c *sourceCrypt, name string, inTree bool) (src.Object, src.Reference) {
+	root, _ := r.Trailer().Get("Root")
+	cat, owner := resolveOwned(r, root, src.Reference{})
+	if !inTree {
+		dests, owner := resolveOwned(r, entry(asDict(cat), "Dests"), owner)
+		v, _ := asDict(dests).Get(name)
+		return v, owner
+	}
+	names, owner := resolveOwned(r, entry(asDict(cat), "Names"), owner)
+	tree, owner := resolveOwned(r, entry(asDict(names), "Dests"), owner)
+	if tree, ok := tree.(*src.Dict); ok {
+		return lookupNameTree(r, c, tree, owner, name, 0)
+	}
+	return nil, owner
+}
+
+// asDict returns obj as a dictionary, nil when it is none.
+func asDict(obj src.Object) *src.Dict {
+	d, _ := obj.(*src.Dict)
+	return d
+}
+
+// lookupNameTree finds key in the name tree rooted at node, a part of the
+// object owner.
+func lookupNameTree(r *src.Reader, c *sourceCrypt, node *src.Dict, owner src.Reference, key string, depth int) (src.Object, src.Reference) {
+	if depth > maxDestDepth {
+		return nil, owner
+	}
+	obj, namesOwner := resolveOwned(r, entry(node, "Names"), owner)
+	names, _ := obj.(src.Array)
+	for i := 0; i+1 < len(names); i += 2 {
+		k, kOwner := resolveOwned(r, names[i], namesOwner)
+		if k, ok := k.(src.String); ok && string(c.plain(k, kOwner)) == key {
+			return names[i+1], namesOwner
+		}
+	}
+	obj, kidsOwner := resolveOwned(r, entry(node, "Kids"), owner)
+	kids, _ := obj.(src.Array)
+	for _, kid := range kids {
+		kd, kidOwner := resolveOwned(r, kid, kidsOwner)
+		kdict, ok := kd.(*src.Dict)
+		if !ok {
+			continue
+		}
+		limits, limitsOwner := resolveOwned(r, entry(kdict, "Limits"), kidOwner)
+		if limits, ok := limits.(src.Array); ok && len(limits) == 2 {
+			lo, loOwner := resolveOwned(r, limits[0], limitsOwner)
+			hi, hiOwner := resolveOwned(r, limits[1], limitsOwner)
+			loKey, _ := lo.(src.String)
+			hiKey, _ := hi.(src.String)
+			if key < string(c.plain(loKey, loOwner)) || key > string(c.plain(hiKey, hiOwner)) {
+				continue
+			}
+		}
+		if v, vOwner := lookupNameTree(r, c, kdict, kidOwner, key, depth+1); v != nil {
+			return v, vOwner
+		}
+	}
+	return nil, owner
+}
+
+// actionDest returns the destination of a /GoTo action, or nil, together
+// with the object that holds it. owner is the object holding action.
+func actionDest(r *src.Reader, action src.Object, owner src.Reference) (src.Object, src.Reference) {
+	obj, owner := resolveOwned(r, action, owner)
+	a, ok := obj.(*src.Dict)
+	if !ok {
+		return nil, owner
+	}
+	if s, _ := a.Name("S"); s != "GoTo" {
+		return nil, owner
+	}
+	d, _ := a.Get("D")
+	return d, owner
+}
+
+// resolveDest resolves dest, a destination object of the given source and a
+// part of the object owner, and maps it to the template of its page if there
+// is one.
+func (pw *PdfWriter) resolveDest(source int, dest src.Object, owner src.Reference) (ResolvedDest, bool) {
+	if pw.pageNums == nil {
+		pw.pageNums = make(map[int]map[*src.Dict]int)
+	}
+	pages, ok := pw.pageNums[source]
+	if !ok {
+		pages = pageNumbers(pw.sources[source])
+		pw.pageNums[source] = pages
+	}
+	sd, ok := parseDest(pw.sources[source], pw.crypt(source), pages, dest, owner)
+	if !ok {
+		return ResolvedDest{}, false
+	}
+	rd := ResolvedDest{Source: source, Page: sd.page}
+	if tplN, ok := pw.pageTpls[pageKey{source: source, page: sd.page}]; ok {
+		rd.Destination = mapDest(tplMatrix(pw.tpls[tplN]), sd)
+		rd.Template = tplN
+	} else {
+		rd.Destination = mapDest(IdentityMatrix, sd)
+		rd.Template = -1
+	}
+	return rd, true
+}
+
+// mapDest transforms sd by m.
+func mapDest(m Matrix, sd sourceDest) Destination {
+	nan := math.NaN()
+	d := Destination{Fit: sd.fit, Left: nan, Bottom: nan, Right: nan, Top: nan, Zoom: nan}
+	param := func(i int) float64 {
+		if i < len(sd.params) {
+			return sd.params[i]
+		}
+		return nan
+	}
+	// A quarter turn exchanges the axes: a horizontal position becomes a
+	// vertical one, and FitH becomes FitV.
+	swap := math.Abs(m[0]) < 1e-9
+	apply := func(x, y float64) (float64, float64) {
+		nx, ny := m.Apply(zeroNaN(x), zeroNaN(y))
+		if swap {
+			x, y = y, x
+		}
+		return keepNaN(x, nx), keepNaN(y, ny)
+	}
+	switch sd.fit {
+	case "XYZ":
+		// (left, top) is the point shown at the upper left corner of the
+		// window: its image under m is the corner in form space. A null
+		// coordinate, which keeps the current one, stays null on the axis
+		// it is turned onto.
+		left, top := param(0), param(1)
+		x, y := m.Apply(zeroNaN(left), zeroNaN(top))
+		fromX, fromY := left, top
+		if swap {
+			fromX, fromY = top, left
+		}
+		d.Left, d.Top = keepNaN(fromX, x), keepNaN(fromY, y)
+		d.Zoom = param(2)
+	case "FitH", "FitBH":
+		x, y := apply(nan, param(0))
+		if swap {
+			d.Fit, d.Left = "FitV", x
+			if sd.fit == "FitBH" {
+				d.Fit = "FitBV"
+			}
+		} else {
+			d.Top = y
+		}
+	case "FitV", "FitBV":
+		x, y := apply(param(0), nan)
+		if swap {
+			d.Fit, d.Top = "FitH", y
+			if sd.fit == "FitBV" {
+				d.Fit = "FitBH"
+			}
+		} else {
+			d.Left = x
+		}
+	case "FitR":
+		r := m.transformRect([4]float64{param(0), param(1), param(2), param(3)})
+		d.Left, d.Bottom, d.Right, d.Top = r[0], r[1], r[2], r[3]
+	}
+	return d
+}
+
+// zeroNaN replaces NaN with 0.
+func zeroNaN(f float64) float64 {
+	if math.IsNaN(f) {
+		return 0
+	}
+	return f
+}
+
+// keepNaN returns f, or NaN when the coordinate it came from was null.
+func keepNaN(from, f float64) float64 {
+	if math.IsNaN(from) {
+		return math.NaN()
+	}
+	return f
+}
@@ -0,0 +1,233 @@
+package gofpdi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Document assembles a complete PDF file from imported templates, for tools
+// that have no host PDF writer of their own (merge, split, n-up). It owns an
+// Importer whose object numbering it controls; import pages through
+// Document.Importer, add pages that place the templates, then call WriteTo.
+//
+//	doc := gofpdi.NewDocument()
+//	imp := doc.Importer()
+//	imp.SetSourceStream(f)
+//	tpl, _ := imp.ImportPage(1, "/MediaBox")
+//	doc.AddTemplatePage(tpl)
+//	doc.WriteTo(out)
+//
+// The Importer must not be given an external allocator (SetObjIDGetter) or
+// an ObjectSink; everything else, such as ImportOutlines with the page
+// numbers from PageObjID, works as with a host writer. Annotations are added
+// with AddAnnots rather than ImportAnnots, since their object numbers only
+// exist once WriteTo has written the templates.
+type Document struct {
+	imp     *Importer
+	catalog int
+	pages   int // object number of the page tree root
+	page    []*docPage
+}
+
+// docPage is one page of a Document.
+type docPage struct {
+	objID         int
+	width, height float64
+	placements    []placement
+	annotSets     []int // ImportAnnots handles, listed in /Annots
+}
+
+// placement is a template drawn on a page with a transformation.
+type placement struct {
+	tpl int
+	m   Matrix
+}
+
+// NewDocument returns an empty Document.
+func NewDocument() *Document {
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	return &Document{
+		imp:     imp,
+		catalog: imp.writer.reserveObjectID(),
+		pages:   imp.writer.reserveObjectID(),
+	}
+}
+
+// Importer returns the Importer that imports pages for the document.
+func (doc *Document) Importer() *Importer {
+	return doc.imp
+}
+
+// AddPage appends an empty page of the given size in points and returns its
+// 0-based index.
+func (doc *Document) AddPage(width, height float64) int {
+	doc.page = append(doc.page, &docPage{
+		objID:  doc.imp.writer.reserveObjectID(),
+		width:  width,
+		height: height,
+	})
+	return len(doc.page) - 1
+}
+
+// AddTemplatePage appends a page exactly the size of template tplN with the
+// template filling it, and returns the page index.
+func (doc *Document) AddTemplatePage(tplN int) (int, error) {
+	w, h, m, err := doc.imp.writer.templateExtent(tplN)
+	if err != nil {
+		return 0, err
+	}
+	page := doc.AddPage(w, h)
+	return page, doc.Place(page, tplN, m)
+}
+
+// Place draws template tplN on page, transformed by m (the matrix a cm
+// operator in front of the Do would carry). Templates are drawn in the order
+// they are placed.
+func (doc *Document) Place(page, tplN int, m Matrix) error {
+	if page < 0 || page >= len(doc.page) {
+		return fmt.Errorf("gofpdi: unknown page %d", page)
+	}
+	if tplN < 0 || tplN >= len(doc.imp.writer.tpls) {
+		return fmt.Errorf("gofpdi: unknown template %d", tplN)
+	}
+	doc.page[page].placements = append(doc.page[page].placements, placement{tpl: tplN, m: m})
+	return nil
+}
+
+// AddAnnots copies the annotations of template tplN onto page, mapped
+// through m as for ImportAnnots; pass the matrix the template is placed with.
+// WriteTo lists the copies in the page's /Annots.
+func (doc *Document) AddAnnots(page, tplN int, m Matrix) error {
+	if page < 0 || page >= len(doc.page) {
+		return fmt.Errorf("gofpdi: unknown page %d", page)
+	}
+	set, err := doc.imp.ImportAnnots(tplN, m)
+	if err != nil {
+		return err
+	}
+	doc.page[page].annotSets = append(doc.page[page].annotSets, set)
+	return nil
+}
+
+// PageObjID returns the object number of page, for link destinations
+// (SetDestinationFunc) and structure grafting (StructGraft.Page).
+func (doc *Document) PageObjID(page int) int {
+	if page < 0 || page >= len(doc.page) {
+		return 0
+	}
+	return doc.page[page].objID
+}
+
+// NumPages returns the number of pages added so far.
+func (doc *Document) NumPages() int {
+	return len(doc.page)
+}
+
+// WriteTo serializes the templates through PutFormXobjects and writes the
+// complete PDF file to w. It can be called once.
+func (doc *Document) WriteTo(w io.Writer) (int64, error) {
+	names, err := doc.imp.PutFormXobjects()
+	if err != nil {
+		return 0, err
+	}
+	pw := doc.imp.writer
+	objs := maps.Clone(pw.writtenObjs)
+
+	kids := make([]string, len(doc.page))
+	for i, p := range doc.page {
+		kids[i] = fmt.Sprintf("%d 0 R", p.objID)
+		var content bytes.Buffer
+		var xobjs []string
+		for _, pl := range p.placements {
+			name := fmt.Sprintf("GOFPDITPL%d", pl.tpl)
+			fmt.Fprintf(&content, "q %s /%s Do Q\n", pl.m.CM(), name)
+			entry := fmt.Sprintf("/%s %d 0 R", name, names["/"+name])
+			if !slices.Contains(xobjs, entry) {
+				xobjs = append(xobjs, entry)
+			}
+		}
+		contentID := pw.reserveObjectID()
+		objs[contentID] = fmt.Appendf(nil, "<</Length %d>>\nstream\n%s\nendstream", content.Len(), content.Bytes())
+
+		var page bytes.Buffer
+		fmt.Fprintf(&page, "<</Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources <</XObject <<%s>>>> /Contents %d 0 R",
+			doc.pages, formatNumber(p.width), formatNumber(p.height), strings.Join(xobjs, " "), contentID)
+		var annots []string
+		for _, set := range p.annotSets {
+			for _, a := range doc.imp.GetImportedAnnots(set) {
+				annots = append(annots, fmt.Sprintf("%d 0 R", a.ObjID))
+			}
+		}
+		if len(annots) > 0 {
+			fmt.Fprintf(&page, " /Annots [%s]", strings.Join(annots, " "))
+		}
+		page.WriteString(">>")
+		objs[p.objID] = page.Bytes()
+	}
+	objs[doc.pages] = fmt.Appendf(nil, "<</Type /Pages /Kids [%s] /Count %d>>", strings.Join(kids, " "), len(doc.page))
+	objs[doc.catalog] = fmt.Appendf(nil, "<</Type /Catalog /Pages %d 0 R>>", doc.pages)
+
+	cw := &countWriter{w: w}
+	writePDF(cw, objs, doc.catalog)
+	return cw.n, cw.err
+}
+
+// writePDF writes a PDF file of the object bodies in objs with a classic
+// cross-reference table. Object numbers missing from objs are marked free.
+func writePDF(w io.Writer, objs map[int][]byte, root int) {
+	size := 1
+	for n := range objs {
+		size = max(size, n+1)
+	}
+	offsets := make([]int, size)
+	var pos int
+	put := func(b []byte) {
+		n, _ := w.Write(b)
+		pos += n
+	}
+	put([]byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n"))
+	for n := 1; n < size; n++ {
+		body, ok := objs[n]
+		if !ok {
+			continue
+		}
+		offsets[n] = pos
+		put(fmt.Appendf(nil, "%d 0 obj\n", n))
+		put(body)
+		put([]byte("\nendobj\n"))
+	}
+	xref := pos
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", size)
+	for n := 1; n < size; n++ {
+		if _, ok := objs[n]; ok {
+			fmt.Fprintf(&b, "%010d 00000 n \n", offsets[n])
+		} else {
+			b.WriteString("0000000000 00000 f \n")
+		}
+	}
+	fmt.Fprintf(&b, "trailer\n<</Size %d /Root %d 0 R>>\nstartxref\n%d\n%%%%EOF\n", size, root, xref)
+	put(b.Bytes())
+}
+
+// countWriter counts the bytes written and keeps the first error.
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
@@ -0,0 +1,171 @@
+package gofpdi
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"testing"
+
+	src "github.com/speedata/pdfdisassembler"
+)
+
+func TestDocument(t *testing.T) {
+	doc := NewDocument()
+	imp := doc.Importer()
+	var tpls []int
+	for _, in := range []struct {
+		file  string
+		pages []int
+	}{{"testdata/cow.pdf", []int{1}}, {"testdata/sample.pdf", []int{1, 2}}} {
+		f, err := os.Open(in.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		h, err := imp.AddSourceStream(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range in.pages {
+			tpl, err := imp.ImportPageFrom(h, p, "/MediaBox")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tpls = append(tpls, tpl)
+			if _, err := doc.AddTemplatePage(tpl); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// A page rotated a quarter turn comes out upright, with width and height
+	// exchanged.
+	rotated := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [10 10 210 110] /Rotate 90>>",
+	}, "")
+	h, err := imp.AddSourceStream(bytes.NewReader(rotated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl, err := imp.ImportPageFrom(h, 1, "/MediaBox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := doc.AddTemplatePage(tpl); err != nil {
+		t.Fatal(err)
+	}
+
+	// A 2-up sheet with two templates at half size.
+	sheet := doc.AddPage(595, 421)
+	doc.Place(sheet, tpls[1], Matrix{0.5, 0, 0, 0.5, 0, 0})
+	doc.Place(sheet, tpls[1], Matrix{0.5, 0, 0, 0.5, 297.5, 0})
+	if err := doc.Place(sheet, 99, IdentityMatrix); err == nil {
+		t.Error("Place with an unknown template should fail")
+	}
+
+	var out bytes.Buffer
+	n, err := doc.WriteTo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(out.Len()) {
+		t.Errorf("WriteTo reported %d bytes, wrote %d", n, out.Len())
+	}
+
+	rd, err := src.Open(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("re-parse document: %v", err)
+	}
+	pages, err := rd.Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 5 {
+		t.Fatalf("got %d pages, want 5", len(pages))
+	}
+	// Every page takes the size of its template; cow.pdf is not checked.
+	want := [][2]float64{{0, 0}, {595, 842}, {595, 842}, {100, 200}, {595, 421}}
+	for i, p := range pages {
+		mb, _ := p.Box(src.MediaBox)
+		if i > 0 && (math.Abs(mb.Width()-want[i][0]) > 0.01 || math.Abs(mb.Height()-want[i][1]) > 0.01) {
+			t.Errorf("page %d is %vx%v, want %vx%v", i+1, mb.Width(), mb.Height(), want[i][0], want[i][1])
+		}
+		res, _ := p.Resources()
+		xobj, _ := res.Dict("XObject")
+		if len(xobj.Keys()) == 0 {
+			t.Errorf("page %d places no template", i+1)
+		}
+		content, err := p.Content()
+		if err != nil || !bytes.Contains(content, []byte(" Do Q")) {
+			t.Errorf("page %d content = %q, %v", i+1, content, err)
+		}
+	}
+}
+
+func TestDocumentAnnots(t *testing.T) {
+	// A link to its own page, placed at half size on a sheet.
+	pdf := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] /Annots [4 0 R]>>",
+		"<</Type /Annot /Subtype /Link /Rect [10 20 110 40] /Dest [3 0 R /Fit]>>",
+	}, "")
+	doc := NewDocument()
+	imp := doc.Importer()
+	if err := imp.SetSourceStream(bytes.NewReader(pdf)); err != nil {
+		t.Fatal(err)
+	}
+	tpl, err := imp.ImportPage(1, "/MediaBox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet := doc.AddPage(200, 100)
+	m := Matrix{0.5, 0, 0, 0.5, 100, 0}
+	if err := doc.Place(sheet, tpl, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.AddAnnots(sheet, tpl, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.AddAnnots(sheet, tpl+1, m); err == nil {
+		t.Error("AddAnnots with an unknown template should fail")
+	}
+	imp.SetDestinationFunc(func(rd ResolvedDest) (string, bool) {
+		return fmt.Sprintf("[%d 0 R /Fit]", doc.PageObjID(sheet)), true
+	})
+	var out bytes.Buffer
+	if _, err := doc.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := src.Open(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("re-parse document: %v", err)
+	}
+	page, err := rd.Page(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	annots, _ := page.Dict().Array("Annots")
+	if len(annots) != 1 {
+		t.Fatalf("/Annots = %v, want one annotation", annots)
+	}
+	link, err := rd.Resolve(annots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := link.(*src.Dict)
+	if sub, _ := d.Name("Subtype"); sub != "Link" {
+		t.Errorf("annotation = %v", d)
+	}
+	if rect, ok := dictNumbers(rd, d, "Rect"); !ok || !slices.Equal(rect, []float64{105, 10, 155, 20}) {
+		t.Errorf("/Rect = %v, want [105 10 155 20]", rect)
+	}
+	dest, _ := d.Array("Dest")
+	if target, err := rd.Resolve(dest[0]); err != nil || target != page.Dict() {
+		t.Errorf("/Dest %v does not lead to the sheet: %v", dest, err)
+	}
+}
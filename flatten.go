@@ -0,0 +1,168 @@
+package gofpdi
+
+import (
+	"bytes"
+	"fmt"
+
+	src "github.com/speedata/pdfdisassembler"
+)
+
+// Annotation flags (PDF 32000-1 §12.5.3).
+const (
+	annotHidden = 1 << 1
+	annotPrint  = 1 << 2
+	annotNoView = 1 << 5
+)
+
+// resEntry is a resource a template needs beyond the page's own resources,
+// merged into the Form XObject's /Resources under category (e.g. "XObject").
+// Resources gofpdi generates have a token instead, returning the PDF object
+// to write.
+type resEntry struct {
+	category, name string
+	obj            src.Object
+	owner          src.Reference // the object holding obj, for decryption
+	token          func() string
+}
+
+// flattenAnnots appends the normal appearance of every visible annotation of
+// the page (or only of its form field widgets, see WithFlattenForms) to
+// tpl.content and records the appearance streams as extra XObject
+// resources. The page content is wrapped in q/Q first so the
+// appearances are drawn in default user space no matter what graphics state
+// the page leaves behind.
+func (pw *PdfWriter) flattenAnnots(tpl *pdfTemplate, cfg importConfig) error {
+	r, c := pw.sources[tpl.source], pw.crypt(tpl.source)
+	annots, annotsOwner := resolveOwned(r, entry(tpl.page.Dict(), "Annots"), c.pageRef(tpl.page.Index()))
+	arr, _ := annots.(src.Array)
+	var af acroForm
+	if cfg.flattenForms {
+		af = loadAcroForm(r, c)
+	}
+	var ops bytes.Buffer
+	for _, e := range arr {
+		obj, err := r.Resolve(e)
+		if err != nil {
+			return fmt.Errorf("gofpdi: resolve annotation: %w", err)
+		}
+		owner := annotsOwner
+		if ref, ok := e.(src.Reference); ok {
+			owner = ref
+		}
+		d, ok := obj.(*src.Dict)
+		if !ok {
+			continue
+		}
+		print := cfg.annotsToPrint
+		if sub, _ := d.Name("Subtype"); sub == "Widget" && cfg.flattenForms {
+			print = true
+		} else if !cfg.flattenAnnots {
+			continue
+		}
+		if !annotVisible(d, print) {
+			continue
+		}
+		if cfg.flattenForms {
+			if field, ok := pw.fieldAppearance(tpl, d, owner, af); ok {
+				ops.WriteString(field)
+				markFlattened(tpl, d)
+				continue
+			}
+		}
+		ref, ap, ok := normalAppearance(r, d)
+		if !ok {
+			continue
+		}
+		rect, ok := dictNumbers(r, d, "Rect")
+		if !ok || len(rect) != 4 {
+			continue
+		}
+		// PDF 32000-1 §12.5.5: the appearance's bounding box, transformed by
+		// its /Matrix, is fitted into /Rect. The Do operator applies /Matrix
+		// itself, so the cm only has to do the fitting.
+		bbox, ok := dictNumbers(r, ap.Dict, "BBox")
+		if !ok || len(bbox) != 4 {
+			continue
+		}
+		m := IdentityMatrix
+		if nums, ok := dictNumbers(r, ap.Dict, "Matrix"); ok && len(nums) == 6 {
+			m = Matrix(nums)
+		}
+		fit, ok := mapRect(m.transformRect([4]float64(bbox)), normalizeRect([4]float64(rect)))
+		if !ok {
+			continue
+		}
+		name := fmt.Sprintf("GOFPDIAP%d", len(tpl.extraRes))
+		tpl.extraRes = append(tpl.extraRes, resEntry{category: "XObject", name: name, obj: ref})
+		markFlattened(tpl, d)
+		fmt.Fprintf(&ops, "q %s /%s Do Q\n", fit.CM(), name)
+	}
+	if ops.Len() > 0 {
+		content := make([]byte, 0, len(tpl.content)+ops.Len()+6)
+		content = append(content, "q\n"...)
+		content = append(content, tpl.content...)
+		content = append(content, "\nQ\n"...)
+		tpl.content = append(content, ops.Bytes()...)
+	}
+	return nil
+}
+
+// markFlattened records that annotation d is part of the template content.
+func markFlattened(tpl *pdfTemplate, d *src.Dict) {
+	if tpl.flattened == nil {
+		tpl.flattened = make(map[*src.Dict]bool)
+	}
+	tpl.flattened[d] = true
+}
+
+// annotVisible reports whether a viewer draws the annotation d, on screen or,
+// with print set, when printing.
+func annotVisible(d *src.Dict, print bool) bool {
+	if sub, _ := d.Name("Subtype"); sub == "Popup" {
+		return false // drawn by the viewer only while open
+	}
+	f, _ := d.Int("F")
+	switch {
+	case f&annotHidden != 0:
+		return false
+	case print:
+		return f&annotPrint != 0
+	default:
+		return f&annotNoView == 0
+	}
+}
+
+// normalAppearance returns the normal appearance stream of annotation d and
+// its reference. For annotations with appearance states, /AS selects the
+// stream.
+func normalAppearance(r *src.Reader, d *src.Dict) (src.Reference, *src.Stream, bool) {
+	ap, ok := d.Dict("AP")
+	if !ok {
+		return src.Reference{}, nil, false
+	}
+	n, _ := ap.Get("N")
+	if states, ok := resolveIn(r, n).(*src.Dict); ok {
+		as, ok := d.Name("AS")
+		if !ok {
+			return src.Reference{}, nil, false
+		}
+		n, _ = states.Get(string(as))
+	}
+	ref, ok := n.(src.Reference)
+	if !ok {
+		return src.Reference{}, nil, false
+	}
+	stream, ok := resolveIn(r, ref).(*src.Stream)
+	return ref, stream, ok
+}
+
+// normalizeRect orders the corners of r as lower-left, upper-right.
+func normalizeRect(r [4]float64) [4]float64 {
+	if r[0] > r[2] {
+		r[0], r[2] = r[2], r[0]
+	}
+	if r[1] > r[3] {
+		r[1], r[3] = r[3], r[1]
+	}
+	return r
+}
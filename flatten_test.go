@@ -0,0 +1,128 @@
+package gofpdi
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// annotsFixture is a one-page PDF with a printable stamp, a hidden
+// annotation, a screen-only note and a checked checkbox widget.
+func annotsFixture() []byte {
+	ap := "<</Type /XObject /Subtype /Form /BBox [0 0 10 10] /Length 8>>\nstream\n0 0 m f\n\nendstream"
+	return buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] /Contents 4 0 R /Annots [5 0 R 6 0 R 7 0 R 8 0 R]>>",
+		"<</Length 8>>\nstream\n1 0 0 rg\nendstream",
+		"<</Type /Annot /Subtype /Stamp /F 4 /Rect [50 50 70 60] /AP <</N 9 0 R>>>>",
+		"<</Type /Annot /Subtype /Stamp /F 6 /Rect [0 0 10 10] /AP <</N 9 0 R>>>>",
+		"<</Type /Annot /Subtype /Text /Rect [0 0 10 10] /AP <</N 9 0 R>>>>",
+		"<</Type /Annot /Subtype /Widget /F 4 /Rect [10 10 20 20] /AS /Yes /AP <</N <</Yes 10 0 R /Off 9 0 R>>>>>>",
+		ap,
+		"<</Type /XObject /Subtype /Form /BBox [0 0 5 5] /Matrix [0 1 -1 0 0 0] /Length 0>>\nstream\n\nendstream",
+	}, "")
+}
+
+func TestImportFlattenAnnots(t *testing.T) {
+	for _, print := range []bool{true, false} {
+		imp := NewImporter()
+		imp.SetNextObjectID(1)
+		if err := imp.SetSourceStream(bytes.NewReader(annotsFixture())); err != nil {
+			t.Fatal(err)
+		}
+		tpl, err := imp.ImportPage(1, "/MediaBox", WithFlattenAnnots(print))
+		if err != nil {
+			t.Fatal(err)
+		}
+		set, _ := imp.ImportAnnots(tpl, IdentityMatrix)
+		names, err := imp.PutFormXobjects()
+		if err != nil {
+			t.Fatal(err)
+		}
+		head, content := formContent(t, imp.GetImportedObjects()[names["/GOFPDITPL0"]])
+
+		want := []string{
+			"q\n1 0 0 rg\nQ\n",
+			"q 2 0 0 1 50 50 cm /GOFPDIAP0 Do Q\n",
+			// The rotated 5×5 box [-5 0 0 5] is fitted into [10 10 20 20].
+			"q 2 0 0 2 20 10 cm /GOFPDIAP1 Do Q\n",
+		}
+		if !print {
+			want = []string{
+				"q\n1 0 0 rg\nQ\n",
+				"q 2 0 0 1 50 50 cm /GOFPDIAP0 Do Q\n",
+				"q 1 0 0 1 0 0 cm /GOFPDIAP1 Do Q\n",
+				"q 2 0 0 2 20 10 cm /GOFPDIAP2 Do Q\n",
+			}
+		}
+		for _, w := range want {
+			if !strings.Contains(string(content), w) {
+				t.Errorf("print=%v: content lacks %q:\n%s", print, w, content)
+			}
+		}
+		if n := strings.Count(string(content), " Do "); n != len(want)-1 {
+			t.Errorf("print=%v: %d appearances drawn, want %d", print, n, len(want)-1)
+		}
+		if !bytes.Contains(head, []byte("/XObject <</GOFPDIAP0 ")) {
+			t.Errorf("appearances missing from /Resources: %s", head)
+		}
+		// Only what was not flattened remains for ImportAnnots.
+		left := 2
+		if !print {
+			left = 1
+		}
+		if got := len(imp.GetImportedAnnots(set)); got != left {
+			t.Errorf("print=%v: %d annotations left interactive, want %d", print, got, left)
+		}
+	}
+}
+
+func TestImportFlattenForms(t *testing.T) {
+	pdf := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R /AcroForm <</Fields [5 0 R 6 0 R] /DA (/Helv 0 Tf 0 g) /DR <</Font <</Helv 9 0 R>>>>>>>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] /Contents 4 0 R /Annots [5 0 R 7 0 R 8 0 R]>>",
+		"<</Length 0>>\nstream\n\nendstream",
+		"<</Type /Annot /Subtype /Widget /F 4 /FT /Tx /T (name) /V (Hello) /Q 1 /DA (/Helv 12 Tf 0 0 1 rg) /Rect [10 10 110 30]>>",
+		"<</FT 10 0 R /T (code) /Ff 16777216 /MaxLen 4 /V <FEFF004100420031> /Kids [7 0 R]>>",
+		"<</Type /Annot /Subtype /Widget /F 4 /Parent 6 0 R /Rect [0 50 40 60]>>",
+		"<</Type /Annot /Subtype /Link /Rect [0 0 10 10]>>",
+		"<</Type /Font /Subtype /Type1 /BaseFont /Helvetica>>",
+		"/Tx",
+	}, "")
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(bytes.NewReader(pdf)); err != nil {
+		t.Fatal(err)
+	}
+	tpl, err := imp.ImportPage(1, "/MediaBox", WithFlattenForms())
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, _ := imp.ImportAnnots(tpl, IdentityMatrix)
+	names, err := imp.PutFormXobjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, content := formContent(t, imp.GetImportedObjects()[names["/GOFPDITPL0"]])
+
+	for _, w := range []string{
+		"q 1 0 0 1 10 10 cm\n",
+		"BT /GOFPDIF0 12 Tf 0 0 1 rg\n",
+		// "Hello" is 30pt wide at half an em per character, centred in 100pt.
+		"1 0 0 1 35 6.64 Tm (Hello) Tj\n",
+		"BT /GOFPDIF1 5.21739 Tf 0 g\n",
+		"(A) Tj", "(B) Tj", "(1) Tj",
+	} {
+		if !strings.Contains(string(content), w) {
+			t.Errorf("content lacks %q:\n%s", w, content)
+		}
+	}
+	if !bytes.Contains(head, []byte("/Font <</GOFPDIF0 ")) {
+		t.Errorf("field fonts missing from /Resources: %s", head)
+	}
+	if annots := imp.GetImportedAnnots(set); len(annots) != 1 || annots[0].Subtype != "Link" {
+		t.Errorf("interactive annotations left = %+v, want the link only", annots)
+	}
+}
@@ -0,0 +1,289 @@
+package gofpdi
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	src "github.com/speedata/pdfdisassembler"
+)
+
+// Field flags (PDF 32000-1 §12.7.3.1, §12.7.4.3, §12.7.4.4).
+const (
+	fieldMultiline = 1 << 12
+	fieldPassword  = 1 << 13
+	fieldCombo     = 1 << 17
+	fieldComb      = 1 << 24
+)
+
+// fieldPadding is the inset of generated field text from the widget border.
+const fieldPadding = 2
+
+// acroForm is the interactive form dictionary of a source, as far as
+// appearance generation needs it.
+type acroForm struct {
+	da               string        // document-wide default appearance
+	dr               *src.Dict     // document-wide default resources
+	drOwner          src.Reference // the object holding dr
+	needsAppearances bool          // /NeedAppearances: existing /AP are stale
+}
+
+// loadAcroForm reads the catalog's /AcroForm of r, decrypting with c. A
+// source without one yields the zero value.
+func loadAcroForm(r *src.Reader, c *sourceCrypt) acroForm {
+	var af acroForm
+	root, _ := r.Trailer().Get("Root")
+	cat, owner := resolveOwned(r, root, src.Reference{})
+	obj, owner := resolveOwned(r, entry(asDict(cat), "AcroForm"), owner)
+	d, ok := obj.(*src.Dict)
+	if !ok {
+		return af
+	}
+	da, daOwner := resolveOwned(r, entry(d, "DA"), owner)
+	if da, ok := da.(src.String); ok {
+		af.da = string(c.plain(da, daOwner))
+	}
+	dr, drOwner := resolveOwned(r, entry(d, "DR"), owner)
+	af.dr, _ = dr.(*src.Dict)
+	af.drOwner = drOwner
+	af.needsAppearances, _ = d.Bool("NeedAppearances")
+	return af
+}
+
+// fieldAttr returns the field attribute key of widget d, a part of the
+// object owner, following the /Parent chain for inheritable attributes. It
+// returns the attribute with the object it is part of.
+func fieldAttr(d *src.Dict, owner src.Reference, key string) (src.Object, src.Reference, bool) {
+	for depth := 0; d != nil && depth < 32; depth++ {
+		if v, ok := d.Get(key); ok {
+			return v, owner, true
+		}
+		if ref, ok := entry(d, "Parent").(src.Reference); ok {
+			owner = ref
+		}
+		d, _ = d.Dict("Parent")
+	}
+	return nil, owner, false
+}
+
+// fieldAppearance generates the appearance of a text field or combo box
+// widget d that has no appearance stream, or whose appearance the form marks
+// as stale. It returns content stream operators drawing the field value in
+// default user space and registers the font as an extra resource of tpl.
+// ok is false for other fields, which keep their own appearance. owner is
+// the object d is part of.
+func (pw *PdfWriter) fieldAppearance(tpl *pdfTemplate, d *src.Dict, owner src.Reference, af acroForm) (string, bool) {
+	r, c := pw.sources[tpl.source], pw.crypt(tpl.source)
+	if d.Has("AP") && !af.needsAppearances {
+		return "", false
+	}
+	var ft src.Object
+	if v, _, ok := fieldAttr(d, owner, "FT"); ok {
+		ft = resolveIn(r, v)
+	}
+	var ff int64
+	if v, _, ok := fieldAttr(d, owner, "Ff"); ok {
+		if n, ok := resolveIn(r, v).(src.Integer); ok {
+			ff = int64(n)
+		}
+	}
+	switch {
+	case ft == src.Name("Tx") && ff&fieldPassword == 0:
+	case ft == src.Name("Ch") && ff&fieldCombo != 0:
+	default:
+		return "", false
+	}
+	rect, ok := dictNumbers(r, d, "Rect")
+	if !ok || len(rect) != 4 {
+		return "", false
+	}
+	box := normalizeRect([4]float64(rect))
+	w, h := box[2]-box[0], box[3]-box[1]
+
+	da := af.da
+	if v, vOwner, ok := fieldAttr(d, owner, "DA"); ok {
+		v, vOwner := resolveOwned(r, v, vOwner)
+		if s, ok := v.(src.String); ok {
+			da = string(c.plain(s, vOwner))
+		}
+	}
+	fontName, size, rest, ok := parseDA(da)
+	if !ok {
+		return "", false
+	}
+	dr, drOwner := af.dr, af.drOwner
+	if v, ok := d.Get("DR"); ok {
+		v, vOwner := resolveOwned(r, v, owner)
+		if res, ok := v.(*src.Dict); ok {
+			dr, drOwner = res, vOwner
+		}
+	}
+	fonts, fontsOwner := resolveOwned(r, entry(dr, "Font"), drOwner)
+	fontObj, ok := asDict(fonts).Get(fontName)
+	if !ok {
+		return "", false
+	}
+	font, _ := resolveIn(r, fontObj).(*src.Dict)
+	text := fieldValue(r, c, d, owner)
+
+	var q int64
+	if v, _, ok := fieldAttr(d, owner, "Q"); ok {
+		if n, ok := resolveIn(r, v).(src.Integer); ok {
+			q = int64(n)
+		}
+	}
+	var maxLen int64
+	if v, _, ok := fieldAttr(d, owner, "MaxLen"); ok {
+		if n, ok := resolveIn(r, v).(src.Integer); ok {
+			maxLen = int64(n)
+		}
+	}
+	multiline := ft == src.Name("Tx") && ff&fieldMultiline != 0
+	if size == 0 {
+		// Auto size: fill the height of a single line, shrinking to fit the
+		// width; multiline fields use a fixed size.
+		size = 12
+		if !multiline {
+			size = min((h-2*fieldPadding)/1.15, 12)
+			if tw := textWidth(r, font, text, 1); tw > 0 {
+				size = min(size, (w-2*fieldPadding)/tw)
+			}
+		}
+	}
+
+	name := fmt.Sprintf("GOFPDIF%d", len(tpl.extraRes))
+	tpl.extraRes = append(tpl.extraRes, resEntry{category: "Font", name: name, obj: fontObj, owner: fontsOwner})
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "q 1 0 0 1 %s %s cm\n", formatNumber(box[0]), formatNumber(box[1]))
+	fmt.Fprintf(&b, "/Tx BMC q %d %d %s %s re W n\nBT /%s %s Tf %s\n", fieldPadding/2, fieldPadding/2,
+		formatNumber(w-fieldPadding), formatNumber(h-fieldPadding), name, formatNumber(size), rest)
+	showAt := func(s []byte, x, y float64) {
+		fmt.Fprintf(&b, "1 0 0 1 %s %s Tm ", formatNumber(x), formatNumber(y))
+		writeLiteral(&b, s)
+		b.WriteString(" Tj\n")
+	}
+	align := func(s []byte) float64 {
+		tw := textWidth(r, font, s, size)
+		switch q {
+		case 1:
+			return (w - tw) / 2
+		case 2:
+			return w - fieldPadding - tw
+		}
+		return fieldPadding
+	}
+	baseline := (h-size)/2 + 0.22*size
+	switch {
+	case multiline:
+		leading := 1.15 * size
+		y := h - fieldPadding - size
+		for _, line := range wrapText(r, font, text, size, w-2*fieldPadding) {
+			showAt(line, align(line), y)
+			y -= leading
+		}
+	case ft == src.Name("Tx") && ff&fieldComb != 0 && maxLen > 0:
+		cell := w / float64(maxLen)
+		for i := 0; i < len(text) && i < int(maxLen); i++ {
+			cw := textWidth(r, font, text[i:i+1], size)
+			showAt(text[i:i+1], float64(i)*cell+(cell-cw)/2, baseline)
+		}
+	default:
+		showAt(text, align(text), baseline)
+	}
+	b.WriteString("ET Q EMC\nQ\n")
+	return b.String(), true
+}
+
+// fieldValue returns the value of a text field or combo box d, a part of the
+// object owner, encoded for a simple font.
+func fieldValue(r *src.Reader, c *sourceCrypt, d *src.Dict, owner src.Reference) []byte {
+	v, owner, ok := fieldAttr(d, owner, "V")
+	if !ok {
+		return nil
+	}
+	v, owner = resolveOwned(r, v, owner)
+	if arr, ok := v.(src.Array); ok && len(arr) > 0 {
+		v, owner = resolveOwned(r, arr[0], owner)
+	}
+	if s, ok := v.(src.String); ok {
+		return simpleText(c.plain(s, owner))
+	}
+	return nil
+}
+
+// simpleText converts a PDF text string to single-byte text. UTF-16BE
+// strings (with byte order mark) are reduced to Latin-1, characters outside
+// it becoming a question mark; PDFDocEncoding strings are kept as they are.
+func simpleText(b []byte) []byte {
+	if len(b) < 2 || b[0] != 0xfe || b[1] != 0xff {
+		return b
+	}
+	out := make([]byte, 0, len(b)/2)
+	for i := 2; i+1 < len(b); i += 2 {
+		c := rune(b[i])<<8 | rune(b[i+1])
+		if c > 0xff {
+			c = '?'
+		}
+		out = append(out, byte(c))
+	}
+	return out
+}
+
+// parseDA splits a default appearance string such as "/Helv 0 Tf 0 g" into
+// the font resource name, the font size and the remaining operators.
+func parseDA(da string) (font string, size float64, rest string, ok bool) {
+	tokens := strings.Fields(da)
+	for i := 2; i < len(tokens); i++ {
+		if tokens[i] != "Tf" || !strings.HasPrefix(tokens[i-2], "/") {
+			continue
+		}
+		size, err := strconv.ParseFloat(tokens[i-1], 64)
+		if err != nil {
+			return "", 0, "", false
+		}
+		others := append(append([]string{}, tokens[:i-2]...), tokens[i+1:]...)
+		return tokens[i-2][1:], size, strings.Join(others, " "), true
+	}
+	return "", 0, "", false
+}
+
+// textWidth returns the width of s set in font at size, from the font's
+// /Widths. Characters the font gives no width, and fonts without /Widths
+// (the standard 14), count half an em.
+func textWidth(r *src.Reader, font *src.Dict, s []byte, size float64) float64 {
+	widths, _ := dictNumbers(r, font, "Widths")
+	first, _ := font.Int("FirstChar")
+	total := 0.0
+	for _, c := range s {
+		w := 500.0
+		if i := int64(c) - first; i >= 0 && i < int64(len(widths)) {
+			w = widths[i]
+		}
+		total += w
+	}
+	return total * size / 1000
+}
+
+// wrapText breaks s into lines no wider than width, at explicit line breaks
+// and between words.
+func wrapText(r *src.Reader, font *src.Dict, s []byte, size, width float64) [][]byte {
+	var lines [][]byte
+	for _, para := range bytes.FieldsFunc(bytes.ReplaceAll(s, []byte("\r\n"), []byte("\n")), func(c rune) bool { return c == '\n' || c == '\r' }) {
+		var line []byte
+		for _, word := range bytes.Fields(para) {
+			candidate := word
+			if len(line) > 0 {
+				candidate = append(append(append([]byte{}, line...), ' '), word...)
+			}
+			if len(line) > 0 && textWidth(r, font, candidate, size) > width {
+				lines = append(lines, line)
+				candidate = word
+			}
+			line = candidate
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -15,12 +15,13 @@ package gofpdi
 import (
 	"fmt"
 	"io"
+	"strings"
 
 	src "github.com/speedata/pdfdisassembler"
 )
 
-// Importer extracts pages from a single source PDF (set via SetSourceStream)
-// and stages them as Form XObjects. Typical lifecycle:
+// Importer extracts pages from one or more source PDFs and stages them as
+// Form XObjects. Typical lifecycle:
 //
 //  1. NewImporter, then SetObjIDGetter to supply the host PDF's object-number
 //     allocator.
@@ -29,20 +30,45 @@ import (
 //  4. PutFormXobjects to serialize the XObjects and every object they
 //     reference, then GetImportedObjects to retrieve the bytes.
 //
-// An Importer is bound to one source PDF and is not safe for concurrent use.
+// To compose a document from several PDFs, register each one with
+// AddSourceStream and import from it with ImportPageFrom (or select it with
+// SetSource). All sources share one object pool: a single PutFormXobjects
+// call emits every template with one consistent output numbering.
+//
+// An Importer is not safe for concurrent use.
 type Importer struct {
+	// reader is the current source, the one with handle source; ImportPage,
+	// GetNumPages and GetPageSizes operate on it.
 	reader *src.Reader
+	source int
 	writer *PdfWriter
-	// importedPages maps a 1-based page number to the template index returned
-	// by ImportPage, so repeated imports of the same page are deduplicated.
-	importedPages map[int]int
+	// importedPages maps a source page, box and import options to the
+	// template index returned by ImportPage, so repeated imports of the same
+	// page are deduplicated.
+	importedPages map[importKey]int
+	// password opens encrypted sources (see SetSourcePassword).
+	password string
+}
+
+// pageKey identifies an imported page: source handle plus page number.
+type pageKey struct {
+	source, page int
+}
+
+// importKey identifies one template of an imported page: a page imported
+// with another box or other options is a template of its own, which shares
+// the copied resources with the others.
+type importKey struct {
+	pageKey
+	box     string // without the leading slash, "" normalized to MediaBox; "" with a clip
+	options string // importConfig.key
 }
 
 // NewImporter returns a ready-to-use Importer.
 func NewImporter() *Importer {
 	return &Importer{
 		writer:        NewPdfWriter(),
-		importedPages: make(map[int]int),
+		importedPages: make(map[importKey]int),
 	}
 }
 
@@ -61,6 +87,28 @@ func (imp *Importer) SetNextObjectID(objID int) {
 	imp.writer.SetNextObjectID(objID)
 }
 
+// SetDeduplicate switches content-hash deduplication on or off. When on, an
+// object whose serialized body (dictionary plus raw stream bytes, with its
+// references compared by content as well) is identical to an object already
+// emitted is not copied again; references to it reuse the existing output
+// number. This shares embedded fonts and ICC profiles between sources and
+// between duplicate objects of one source. GetDedupStats reports the savings.
+//
+// The digest of an object is taken over its full serialized body, stream data
+// included, and covers everything it references. Every object reachable from
+// the templates is therefore serialized once more and hashed, and the first
+// reference to an object digests its whole subgraph before it is copied. Each
+// digest is computed once and reused for every later reference.
+func (imp *Importer) SetDeduplicate(on bool) {
+	imp.writer.setDedup(on)
+}
+
+// GetDedupStats reports how many objects and bytes content-hash
+// deduplication has saved so far.
+func (imp *Importer) GetDedupStats() DedupStats {
+	return imp.writer.dedupStats
+}
+
 // SetTemplateDictEntry stages an extra Form XObject dictionary entry written
 // into the XObject header at PutFormXobjects time. tplN is the template index
 // returned by ImportPage; key is the entry name without the leading slash;
@@ -80,26 +128,60 @@ func (imp *Importer) SetTemplateDictEntry(tplN int, key, value string) {
 	imp.writer.ExtraTemplateDict[tplN][key] = value
 }
 
-// SetSourceStream sets the source PDF. The reader keeps a reference to rs, so
-// rs must stay readable until import is complete.
+// SetSourcePassword sets the password used to open an encrypted source PDF.
+// Either the user or the owner password is accepted. It must be called before
+// SetSourceStream; sources without a user password open without it.
+func (imp *Importer) SetSourcePassword(password string) {
+	imp.password = password
+}
+
+// SetSourceStream registers rs as a new source PDF (see AddSourceStream) and
+// makes it the current source. The reader keeps a reference to rs, so rs must
+// stay readable until import is complete.
+//
+// Sources encrypted with the standard security handler (RC4, AES-128 or
+// AES-256) are decrypted while they are copied: strings and stream bytes are
+// imported as plaintext, stream data stays filter-encoded. Use
+// SetSourcePassword for sources that require a password.
 func (imp *Importer) SetSourceStream(rs io.ReadSeeker) error {
-	r, err := src.Open(rs)
+	source, err := imp.AddSourceStream(rs)
 	if err != nil {
-		return fmt.Errorf("gofpdi: open source PDF: %w", err)
-	}
-	// Importing copies the source's raw, still filter-encoded stream bytes
-	// straight into the output. For an encrypted source those bytes are also
-	// still encrypted, so copying them into an unencrypted output PDF would
-	// produce garbage. Reading metadata from encrypted PDFs works, but page
-	// import does not yet, so reject it loudly rather than emit a broken file.
-	if t := r.Trailer(); t != nil && t.Has("Encrypt") {
-		return fmt.Errorf("gofpdi: importing pages from encrypted PDFs is not supported")
-	}
-	imp.reader = r
-	imp.writer.reader = r
+		return err
+	}
+	return imp.SetSource(source)
+}
+
+// AddSourceStream registers an additional source PDF and returns its handle
+// for ImportPageFrom and SetSource. The current source does not change.
+// Objects copied from different sources share the writer's output numbering.
+// Encrypted sources are opened with the password from SetSourcePassword.
+func (imp *Importer) AddSourceStream(rs io.ReadSeeker) (int, error) {
+	r, c, err := openSource(rs, imp.password)
+	if err != nil {
+		return 0, err
+	}
+	return imp.writer.addSource(r, c), nil
+}
+
+// SetSource makes the source with the given handle the current one for
+// ImportPage, GetNumPages and GetPageSizes.
+func (imp *Importer) SetSource(source int) error {
+	if source < 0 || source >= len(imp.writer.sources) {
+		return fmt.Errorf("gofpdi: unknown source handle %d", source)
+	}
+	imp.reader = imp.writer.sources[source]
+	imp.source = source
 	return nil
 }
 
+// readAll rewinds rs and returns its complete contents.
+func readAll(rs io.ReadSeeker) ([]byte, error) {
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(rs)
+}
+
 // GetNumPages returns the number of pages in the source PDF.
 func (imp *Importer) GetNumPages() (int, error) {
 	if imp.reader == nil {
@@ -132,43 +214,279 @@ func (imp *Importer) GetPageSizes() (map[int]map[string]map[string]float64, erro
 	return out, nil
 }
 
-// ImportPage stages the 1-based page pageno using the requested box (e.g.
-// "/MediaBox"; empty defaults to /MediaBox) and returns the template index to
-// pass to SetTemplateDictEntry. Importing the same page twice returns the
-// previously assigned index without re-staging.
-func (imp *Importer) ImportPage(pageno int, box string) (int, error) {
+// GetPageRotations returns the /Rotate of every page, keyed by 1-based page
+// number: the clockwise rotation in degrees, inherited through /Parent and
+// normalized to 0, 90, 180 or 270.
+func (imp *Importer) GetPageRotations() (map[int]int, error) {
+	if imp.reader == nil {
+		return nil, fmt.Errorf("gofpdi: no source stream set")
+	}
+	pages, err := imp.reader.Pages()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[int]int, len(pages))
+	for _, pg := range pages {
+		out[pg.Index()+1] = pg.Rotation()
+	}
+	return out, nil
+}
+
+// ImportPage stages the 1-based page pageno of the current source using the
+// requested box (e.g. "/MediaBox"; empty defaults to /MediaBox) and returns
+// the template index to pass to SetTemplateDictEntry. Importing the same page
+// twice with the same box and options returns the previously assigned index
+// without re-staging; with another box or other options it becomes a
+// separate template, and the objects both reference are still copied once.
+// opts adjust how the page is staged (see WithFlattenAnnots).
+func (imp *Importer) ImportPage(pageno int, box string, opts ...ImportOption) (int, error) {
 	if imp.reader == nil {
 		return 0, fmt.Errorf("gofpdi: no source stream set")
 	}
-	if tplN, ok := imp.importedPages[pageno]; ok {
-		return tplN, nil
+	return imp.ImportPageFrom(imp.source, pageno, box, opts...)
+}
+
+// ImportPageFrom is ImportPage for the source with the given handle, as
+// returned by AddSourceStream. Template indices are shared by all sources.
+func (imp *Importer) ImportPageFrom(source, pageno int, box string, opts ...ImportOption) (int, error) {
+	tplN, _, err := imp.importPage(source, pageno, box, newImportConfig(opts))
+	return tplN, err
+}
+
+// ImportPages stages the pages of the current source selected by the page
+// range expression spec (see ParsePageRange, e.g. "1-3,odd,last-5") and
+// returns their template indices in the order of spec. Only the selected
+// pages are read. reused lists the page numbers, once per occurrence in
+// spec, that were already imported and returned their earlier template
+// instead of being staged again.
+func (imp *Importer) ImportPages(spec, box string, opts ...ImportOption) (tpls, reused []int, err error) {
+	if imp.reader == nil {
+		return nil, nil, fmt.Errorf("gofpdi: no source stream set")
+	}
+	return imp.ImportPagesFrom(imp.source, spec, box, opts...)
+}
+
+// ImportPagesFrom is ImportPages for the source with the given handle.
+func (imp *Importer) ImportPagesFrom(source int, spec, box string, opts ...ImportOption) (tpls, reused []int, err error) {
+	if source < 0 || source >= len(imp.writer.sources) {
+		return nil, nil, fmt.Errorf("gofpdi: unknown source handle %d", source)
 	}
-	page, err := imp.reader.Page(pageno - 1) // 1-based -> 0-based
+	numPages, err := imp.writer.sources[source].PageCount()
 	if err != nil {
-		return 0, err
+		return nil, nil, err
 	}
-	tplN, err := imp.writer.stageTemplate(page, box)
+	pages, err := ParsePageRange(spec, numPages)
 	if err != nil {
-		return 0, err
+		return nil, nil, err
+	}
+	cfg := newImportConfig(opts)
+	for _, p := range pages {
+		tplN, ok, err := imp.importPage(source, p, box, cfg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("gofpdi: page %d: %w", p, err)
+		}
+		if ok {
+			reused = append(reused, p)
+		}
+		tpls = append(tpls, tplN)
 	}
-	imp.importedPages[pageno] = tplN
-	return tplN, nil
+	return tpls, reused, nil
 }
 
-// PutFormXobjects serializes one Form XObject per imported page plus every
-// object reachable from their resources. It returns a map from the XObject
-// template name (e.g. "/GOFPDITPL0") to its assigned output object number.
-// Retrieve the serialized object bodies with GetImportedObjects.
+// importPage stages page pageno of source, or returns the template of an
+// earlier import of it with reused set.
+func (imp *Importer) importPage(source, pageno int, box string, cfg importConfig) (tplN int, reused bool, err error) {
+	if source < 0 || source >= len(imp.writer.sources) {
+		return 0, false, fmt.Errorf("gofpdi: unknown source handle %d", source)
+	}
+	boxName := strings.TrimPrefix(box, "/")
+	if boxName == "" {
+		boxName = "MediaBox"
+	}
+	if cfg.clip != nil {
+		boxName = "" // the clip rectangle replaces the box
+	}
+	key := importKey{pageKey: pageKey{source: source, page: pageno}, box: boxName, options: cfg.key()}
+	cached := len(cfg.filters) == 0 // filters cannot be compared
+	if tplN, ok := imp.importedPages[key]; ok && cached {
+		return tplN, true, nil
+	}
+	page, err := imp.writer.sources[source].Page(pageno - 1) // 1-based -> 0-based
+	if err != nil {
+		return 0, false, err
+	}
+	tplN, err = imp.writer.stageTemplate(source, page, box, cfg)
+	if err != nil {
+		return 0, false, err
+	}
+	if cached {
+		imp.importedPages[key] = tplN
+	}
+	return tplN, false, nil
+}
+
+// GetTemplateInfo returns the geometry of template tplN as it will be
+// written: its /BBox and /Matrix, the size after rotation and scaling, the
+// effective rotation, and the source page and box it came from.
+func (imp *Importer) GetTemplateInfo(tplN int) (TemplateInfo, error) {
+	return imp.writer.templateInfo(tplN)
+}
+
+// GetTemplateExtent returns the size of template tplN as drawn (its /BBox
+// transformed by its /Matrix, so a rotated page reports its upright size) and
+// the translation that moves the drawn area to the origin. Prepend the
+// translation to a placement matrix to position the template by its lower
+// left corner.
+func (imp *Importer) GetTemplateExtent(tplN int) (width, height float64, origin Matrix, err error) {
+	return imp.writer.templateExtent(tplN)
+}
+
+// PutFormXobjects serializes one Form XObject per imported page, across all
+// sources, plus every object reachable from their resources. It returns a map
+// from the XObject template name (e.g. "/GOFPDITPL0") to its assigned output
+// object number. Retrieve the serialized object bodies with
+// GetImportedObjects.
 func (imp *Importer) PutFormXobjects() (map[string]int, error) {
-	if imp.reader == nil {
+	if len(imp.writer.sources) == 0 {
 		return nil, fmt.Errorf("gofpdi: no source stream set")
 	}
 	return imp.writer.PutFormXobjects()
 }
 
+// PutPageContents is the alternative to PutFormXobjects for hosts that want
+// the imported pages inline: instead of one Form XObject per template it
+// returns, keyed by template index, the page content (with the form matrix
+// as a leading cm, clipped to the box and wrapped in q/Q) and a resource
+// dictionary with collision-free names to merge into the host page. The
+// objects those resources reference are copied as usual and retrieved with
+// GetImportedObjects. Call either PutPageContents or PutFormXobjects, once.
+//
+// The host concatenates its placement matrix before the content:
+//
+//	q <placement> cm <PageContent.Content> Q
+func (imp *Importer) PutPageContents() (map[int]PageContent, error) {
+	if len(imp.writer.sources) == 0 {
+		return nil, fmt.Errorf("gofpdi: no source stream set")
+	}
+	return imp.writer.putPageContents()
+}
+
+// ImportAnnots stages a copy of the annotations (links, notes, stamps, …) of
+// the page behind template tplN and returns a handle for GetImportedAnnots.
+// placement is the transformation the host applies when it draws the template
+// (the cm in front of the Do operator); pass IdentityMatrix for form space.
+// /Rect and the coordinate lists (/QuadPoints, /Vertices, /L, /CL, /InkList)
+// are mapped through the template's /Matrix and then placement. Referenced
+// objects, such as appearance streams and popups, are copied like any other
+// imported object; references to source pages are written as null.
+// Annotations flattened into the template (WithFlattenAnnots) are skipped.
+//
+// The copies are written by PutFormXobjects. Placing the same template on
+// several pages needs one ImportAnnots call per placement.
+func (imp *Importer) ImportAnnots(tplN int, placement Matrix) (int, error) {
+	return imp.writer.stageAnnots(tplN, placement)
+}
+
+// GetImportedAnnots returns the annotations copied for the handle returned by
+// ImportAnnots, in source order. It is empty until PutFormXobjects has run.
+func (imp *Importer) GetImportedAnnots(annotsN int) []Annotation {
+	if annotsN < 0 || annotsN >= len(imp.writer.annotSets) {
+		return nil
+	}
+	return imp.writer.annotSets[annotsN].annots
+}
+
+// ImportStructure stages a copy of the tagged-PDF structure of the page
+// behind template tplN and returns a handle for GetImportedStructure. The
+// structure elements that own marked content of the page are copied, with
+// their ancestors up to the source's StructTreeRoot; kids on other pages are
+// left out. graft names the host objects the copies attach to, and the
+// template gets graft.StructParents as its /StructParents entry (do not also
+// set /StructParent with SetTemplateDictEntry).
+//
+// The marked content of the page keeps its MCIDs: they are local to the Form
+// XObject, and the copied elements address them with marked-content
+// references to it. The host therefore needs no renumbering, only a parent
+// tree entry (StructTree.ParentTree) under graft.StructParents.
+func (imp *Importer) ImportStructure(tplN int, graft StructGraft) (int, error) {
+	return imp.writer.stageStructure(tplN, graft)
+}
+
+// GetImportedStructure returns what ImportStructure copied for the given
+// handle. It is empty until PutFormXobjects has run.
+func (imp *Importer) GetImportedStructure(structN int) *StructTree {
+	if structN < 0 || structN >= len(imp.writer.structSets) {
+		return nil
+	}
+	return &imp.writer.structSets[structN].tree
+}
+
+// GetLayers reports the optional content groups (layers) that the imported
+// pages of the source with the given handle use, and the source's default
+// layer configuration, for the host to merge into its own /OCProperties:
+// add the OCGs to /OCGs and fold On, Off, Order and RBGroups into /D. Each
+// group is copied once, so all templates of the source share its output
+// object number. Call it after PutFormXobjects.
+func (imp *Importer) GetLayers(source int) (*Layers, error) {
+	return imp.writer.layers(source)
+}
+
+// ResolveNamedDest resolves the named destination name of the source with
+// the given handle. Both kinds are looked up: strings in the /Names /Dests
+// tree and, failing that, names in the catalog's /Dests dictionary.
+func (imp *Importer) ResolveNamedDest(source int, name string) (ResolvedDest, error) {
+	if source < 0 || source >= len(imp.writer.sources) {
+		return ResolvedDest{}, fmt.Errorf("gofpdi: unknown source handle %d", source)
+	}
+	for _, dest := range []src.Object{src.String(name), src.Name(name)} {
+		if rd, ok := imp.writer.resolveDest(source, dest, src.Reference{}); ok {
+			return rd, nil
+		}
+	}
+	return ResolvedDest{}, fmt.Errorf("gofpdi: no destination named %q", name)
+}
+
+// ResolveDest resolves dest, a destination read from the source with the
+// given handle: an explicit destination array, a named destination given as
+// a name or string, or a dictionary with /D. This covers the destinations of
+// outline items and links a host reads itself. A string given directly is
+// taken as it is; for an encrypted source it must already be decrypted.
+func (imp *Importer) ResolveDest(source int, dest src.Object) (ResolvedDest, error) {
+	if source < 0 || source >= len(imp.writer.sources) {
+		return ResolvedDest{}, fmt.Errorf("gofpdi: unknown source handle %d", source)
+	}
+	if rd, ok := imp.writer.resolveDest(source, dest, src.Reference{}); ok {
+		return rd, nil
+	}
+	return ResolvedDest{}, fmt.Errorf("gofpdi: destination does not lead to a page of source %d", source)
+}
+
+// SetDestinationFunc installs f to retarget the links of imported
+// annotations (see ImportAnnots). For every link whose /Dest or /GoTo action
+// resolves to a source page, f receives the resolved destination and returns
+// the destination to write instead, as a raw PDF token: an explicit
+// destination such as "[12 0 R /XYZ 0 842 null]" built from the host page the
+// template is placed on, or a name or string of the host's own named
+// destinations. When f returns false, or without f, the page reference of
+// the copied destination is written as null.
+func (imp *Importer) SetDestinationFunc(f func(ResolvedDest) (string, bool)) {
+	imp.writer.destFunc = f
+}
+
+// SetObjectSink switches the importer to streaming output: PutFormXobjects
+// hands every object to sink as soon as it is serialized instead of retaining
+// it for GetImportedObjects. Memory for output then stays bounded by the
+// largest single object, which matters for sources with large images (the
+// source PDFs themselves are still held in memory by the parser). Pass nil to
+// return to buffered output.
+func (imp *Importer) SetObjectSink(sink ObjectSink) {
+	imp.writer.sink = sink
+}
+
 // GetImportedObjects returns the serialized body of every object produced so
 // far, keyed by output object number. The bodies carry no "N 0 obj"/"endobj"
-// wrapper; the host writer adds that when assembling the file.
+// wrapper; the host writer adds that when assembling the file. Objects handed
+// to an ObjectSink (see SetObjectSink) are not included.
 func (imp *Importer) GetImportedObjects() map[int][]byte {
 	return imp.writer.writtenObjs
 }
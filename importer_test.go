@@ -2,6 +2,10 @@ package gofpdi
 
 import (
 	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"math"
 	"os"
 	"testing"
 
@@ -173,6 +177,346 @@ func TestGetPageSizes(t *testing.T) {
 	}
 }
 
+func TestImportMultipleSources(t *testing.T) {
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	var handles []int
+	for _, fn := range []string{"testdata/cow.pdf", "testdata/sample.pdf"} {
+		r, err := os.Open(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		h, err := imp.AddSourceStream(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handles = append(handles, h)
+	}
+	// Page 1 of each source is a distinct template; re-importing is not.
+	tpl0, err := imp.ImportPageFrom(handles[0], 1, "/MediaBox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl1, err := imp.ImportPageFrom(handles[1], 1, "/MediaBox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tpl0 == tpl1 {
+		t.Fatalf("pages from different sources share template %d", tpl0)
+	}
+	if again, _ := imp.ImportPageFrom(handles[1], 1, "/MediaBox"); again != tpl1 {
+		t.Errorf("re-import returned template %d, want %d", again, tpl1)
+	}
+	if _, err := imp.ImportPageFrom(7, 1, "/MediaBox"); err == nil {
+		t.Error("ImportPageFrom with an unknown handle should fail")
+	}
+
+	names, err := imp.PutFormXobjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("expected 2 template names, got %d", len(names))
+	}
+	// One consistent numbering: objects are numbered 1..n without gaps.
+	objs := imp.GetImportedObjects()
+	for n := 1; n <= len(objs); n++ {
+		if _, ok := objs[n]; !ok {
+			t.Fatalf("object %d missing from a numbering of %d objects", n, len(objs))
+		}
+	}
+}
+
+func TestImportPageBoxesAndOptions(t *testing.T) {
+	count := func(imports func(imp *Importer)) int {
+		r, err := os.Open("testdata/sample.pdf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		imp := NewImporter()
+		imp.SetNextObjectID(1)
+		if err := imp.SetSourceStream(r); err != nil {
+			t.Fatal(err)
+		}
+		imports(imp)
+		if _, err := imp.PutFormXobjects(); err != nil {
+			t.Fatal(err)
+		}
+		return len(imp.GetImportedObjects())
+	}
+
+	one := count(func(imp *Importer) { imp.ImportPage(1, "/MediaBox") })
+	two := count(func(imp *Importer) {
+		media, _ := imp.ImportPage(1, "/MediaBox")
+		trim, _ := imp.ImportPage(1, "/TrimBox")
+		if trim == media {
+			t.Error("/TrimBox import returned the /MediaBox template")
+		}
+		for _, box := range []string{"", "MediaBox"} {
+			if again, _ := imp.ImportPage(1, box); again != media {
+				t.Errorf("ImportPage(1, %q) = %d, want %d", box, again, media)
+			}
+		}
+		a, _ := imp.ImportPage(1, "/TrimBox", WithHiddenLayers("x", "y"))
+		b, _ := imp.ImportPage(1, "/TrimBox", WithHiddenLayers("y"), WithHiddenLayers("x"))
+		if a == trim || a != b {
+			t.Errorf("hidden-layer imports: %d and %d (plain %d)", a, b, trim)
+		}
+	})
+	// Three templates, one more Form XObject each; the resources are shared.
+	if two != one+2 {
+		t.Errorf("%d objects for three templates, %d for one", two, one)
+	}
+}
+
+func TestImportClip(t *testing.T) {
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(bytes.NewReader(annotsFixture())); err != nil {
+		t.Fatal(err)
+	}
+	// The rectangle reaches beyond the 200×100 MediaBox and is clamped.
+	tpl, err := imp.ImportPage(1, "/MediaBox", WithClip(100, 120, 0, 40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h, _, _ := imp.GetTemplateExtent(tpl); w != 100 || h != 60 {
+		t.Errorf("extent %gx%g, want 100x60", w, h)
+	}
+	// The clip replaces the box, so another box makes no other template.
+	if again, err := imp.ImportPage(1, "/TrimBox", WithClip(0, 40, 100, 120)); err != nil || again != tpl {
+		t.Errorf("same clip with /TrimBox: template %d, %v; want %d", again, err, tpl)
+	}
+	if _, err := imp.ImportPage(1, "/MediaBox", WithClip(300, 0, 400, 50)); err == nil {
+		t.Error("clip outside the MediaBox succeeded")
+	}
+	set, _ := imp.ImportAnnots(tpl, IdentityMatrix)
+	names, err := imp.PutFormXobjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict, _ := formContent(t, imp.GetImportedObjects()[names["/GOFPDITPL0"]])
+	for _, want := range []string{"/BBox [0.00 40.00 100.00 100.00]", "1.00000 -0.00000 -40.00000]"} {
+		if !bytes.Contains(dict, []byte(want)) {
+			t.Errorf("form dict lacks %q:\n%s", want, dict)
+		}
+	}
+	// Only the stamp at [50 50 70 60] lies inside the cut-out.
+	if annots := imp.GetImportedAnnots(set); len(annots) != 1 || annots[0].Rect != [4]float64{50, 10, 70, 20} {
+		t.Errorf("annotations: %+v", annots)
+	}
+}
+
+func TestImportTransforms(t *testing.T) {
+	r, err := os.Open("testdata/cow.pdf") // 275×200
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(r); err != nil {
+		t.Fatal(err)
+	}
+	diag := 475 / math.Sqrt2
+	cases := []struct {
+		opts   []ImportOption
+		w, h   float64
+		matrix string
+	}{
+		{[]ImportOption{WithRotation(90)}, 200, 275, "/Matrix [0.00000 -1.00000 1.00000 0.00000 0.00000 275.00000]"},
+		{[]ImportOption{WithMirror(true, false)}, 275, 200, "/Matrix [-1.00000 0.00000 0.00000 1.00000 275.00000 0.00000]"},
+		{[]ImportOption{WithRotation(90), WithSize(100, 0)}, 100, 137.5, "/Matrix [0.00000 -0.50000 0.50000 0.00000 0.00000 137.50000]"},
+		{[]ImportOption{WithScale(2, 0.5)}, 550, 100, "/Matrix [2.00000 0.00000 0.00000 0.50000 0.00000 0.00000]"},
+		{[]ImportOption{WithRotation(45)}, diag, diag, ""},
+	}
+	for _, tc := range cases {
+		tpl, err := imp.ImportPage(1, "/MediaBox", tc.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, h, origin, _ := imp.GetTemplateExtent(tpl)
+		if math.Abs(w-tc.w) > 1e-9 || math.Abs(h-tc.h) > 1e-9 || math.Abs(origin[4])+math.Abs(origin[5]) > 1e-9 {
+			t.Errorf("template %d: extent %gx%g at %v, want %gx%g at the origin", tpl, w, h, origin, tc.w, tc.h)
+		}
+	}
+	if _, err := imp.ImportPage(1, "/MediaBox", WithScale(0, 1)); err == nil {
+		t.Error("zero scale factor succeeded")
+	}
+	names, err := imp.PutFormXobjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tc := range cases {
+		dict, _ := formContent(t, imp.GetImportedObjects()[names[fmt.Sprintf("/GOFPDITPL%d", i)]])
+		if !bytes.Contains(dict, []byte(tc.matrix)) {
+			t.Errorf("template %d lacks %q:\n%s", i, tc.matrix, dict)
+		}
+	}
+}
+
+func TestGetTemplateInfo(t *testing.T) {
+	imp := NewImporter()
+	rotated := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [10 10 210 110] /Rotate 90>>",
+	}, "")
+	if err := imp.SetSourceStream(bytes.NewReader(rotated)); err != nil {
+		t.Fatal(err)
+	}
+	tpl, err := imp.ImportPage(1, "CropBox", WithRotation(90))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := imp.GetTemplateInfo(tpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The page /Rotate 90 and another quarter turn: upside down, 200×100.
+	want := TemplateInfo{
+		Page:     1,
+		Box:      "/CropBox",
+		BBox:     [4]float64{10, 10, 210, 110},
+		Matrix:   Matrix{-1, 0, 0, -1, 210, 110},
+		Width:    200,
+		Height:   100,
+		Rotation: 180,
+	}
+	if info != want {
+		t.Errorf("GetTemplateInfo =\n%+v\nwant\n%+v", info, want)
+	}
+
+	clipped, _ := imp.ImportPage(1, "/MediaBox", WithClip(20, 20, 50, 40))
+	if info, _ := imp.GetTemplateInfo(clipped); info.Box != "" || info.BBox != [4]float64{20, 20, 50, 40} || info.Rotation != 90 {
+		t.Errorf("clipped template: %+v", info)
+	}
+	if _, err := imp.GetTemplateInfo(5); err == nil {
+		t.Error("unknown template succeeded")
+	}
+}
+
+func TestImportDeduplicate(t *testing.T) {
+	// The same file registered twice: without deduplication every resource
+	// object is copied once per source, with it only once overall.
+	count := func(dedup bool) (int, DedupStats) {
+		imp := NewImporter()
+		imp.SetNextObjectID(1)
+		imp.SetDeduplicate(dedup)
+		for i := 0; i < 2; i++ {
+			r, err := os.Open("testdata/cow.pdf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			h, err := imp.AddSourceStream(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := imp.ImportPageFrom(h, 1, "/MediaBox"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := imp.PutFormXobjects(); err != nil {
+			t.Fatal(err)
+		}
+		return len(imp.GetImportedObjects()), imp.GetDedupStats()
+	}
+	plain, _ := count(false)
+	deduped, stats := count(true)
+	// Two Form XObjects remain; the resource objects are shared.
+	shared := (plain - 2) / 2
+	if deduped != shared+2 {
+		t.Errorf("with deduplication got %d objects, want %d", deduped, shared+2)
+	}
+	if stats.Objects != shared || stats.Bytes <= 0 {
+		t.Errorf("stats = %+v, want %d objects and a positive byte count", stats, shared)
+	}
+}
+
+// mapSink is an ObjectSink that collects bodies, for comparison with the
+// buffered output.
+type mapSink map[int][]byte
+
+func (m mapSink) WriteObject(num int, body io.Reader) error {
+	b, err := io.ReadAll(body)
+	m[num] = b
+	return err
+}
+
+func TestImportObjectSink(t *testing.T) {
+	run := func(sink ObjectSink) map[int][]byte {
+		r, err := os.Open("testdata/sample.pdf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		imp := NewImporter()
+		imp.SetNextObjectID(1)
+		imp.SetObjectSink(sink)
+		if err := imp.SetSourceStream(r); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := imp.ImportPage(1, "/MediaBox"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := imp.PutFormXobjects(); err != nil {
+			t.Fatal(err)
+		}
+		return imp.GetImportedObjects()
+	}
+	buffered := run(nil)
+	sink := mapSink{}
+	if kept := run(sink); len(kept) != 0 {
+		t.Errorf("streaming mode retained %d objects", len(kept))
+	}
+	if len(sink) != len(buffered) {
+		t.Fatalf("sink received %d objects, buffered mode produced %d", len(sink), len(buffered))
+	}
+	for n, body := range buffered {
+		if !bytes.Equal(sink[n], body) {
+			t.Errorf("object %d differs between streaming and buffered output", n)
+		}
+	}
+}
+
+// formContent splits a serialized Form XObject into its dictionary and its
+// decompressed content.
+func formContent(t *testing.T, form []byte) (dict, content []byte) {
+	t.Helper()
+	dict, body, _ := bytes.Cut(form, []byte("stream\n"))
+	zr, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err = io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dict, content
+}
+
+// buildPDF assembles a fixture from object bodies numbered 1..n, object 1
+// being the catalog. extraTrailer is added to the trailer dictionary.
+func buildPDF(objs []string, extraTrailer string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+	offsets := make([]int, len(objs))
+	for i, body := range objs {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, body)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<</Size %d /Root 1 0 R %s>>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, extraTrailer, xref)
+	return buf.Bytes()
+}
+
 // itoa and pad10 keep the assembled-PDF writer free of fmt/strconv churn.
 func itoa(n int) string {
 	if n == 0 {
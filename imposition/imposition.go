@@ -0,0 +1,224 @@
+// Package imposition arranges imported gofpdi templates on press sheets:
+// n-up grids (2-up, 4-up, …) and saddle-stitched booklets.
+//
+// The layouts only compute where each template goes. A Sheet lists its
+// placements and renders the content stream that draws them through the
+// /GOFPDITPLn Form XObjects; AddSheets puts the sheets into a
+// gofpdi.Document, or a host writer uses Content and XObjects directly.
+//
+//	grid := imposition.Grid{SheetWidth: 842, SheetHeight: 595, Cols: 2, Rows: 1}
+//	sheets, err := grid.Impose(imp, tpls)
+//	imposition.AddSheets(doc, sheets)
+package imposition
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/boxesandglue/gofpdi"
+)
+
+// Blank marks an empty slot in a list of templates, for example a page left
+// free so that a chapter starts on a right-hand page.
+const Blank = -1
+
+// Placement is one template drawn on a sheet.
+type Placement struct {
+	// Template is the template index returned by Importer.ImportPage.
+	Template int
+	// Matrix maps the template's drawn area (see Importer.GetTemplateExtent)
+	// to its position on the sheet, in the cm operator's convention.
+	Matrix gofpdi.Matrix
+}
+
+// Sheet is one side of a press sheet.
+type Sheet struct {
+	Width, Height float64
+	Placements    []Placement
+}
+
+// Content returns the content stream that draws the sheet's templates.
+func (s Sheet) Content() []byte {
+	var b bytes.Buffer
+	for _, p := range s.Placements {
+		fmt.Fprintf(&b, "q %s /GOFPDITPL%d Do Q\n", p.Matrix.CM(), p.Template)
+	}
+	return b.Bytes()
+}
+
+// XObjects returns the names of the Form XObjects Content invokes, without
+// duplicates, for the sheet's /Resources /XObject dictionary. The output
+// object numbers are those returned by PutFormXobjects.
+func (s Sheet) XObjects() []string {
+	var names []string
+	for _, p := range s.Placements {
+		name := fmt.Sprintf("/GOFPDITPL%d", p.Template)
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// AddSheets appends one page per sheet to doc and places the templates on it.
+// It returns the index of the first page added.
+func AddSheets(doc *gofpdi.Document, sheets []Sheet) (int, error) {
+	first := doc.NumPages()
+	for _, s := range sheets {
+		page := doc.AddPage(s.Width, s.Height)
+		for _, p := range s.Placements {
+			if err := doc.Place(page, p.Template, p.Matrix); err != nil {
+				return first, err
+			}
+		}
+	}
+	return first, nil
+}
+
+// Grid is an n-up layout: Cols × Rows cells per sheet, filled left to right
+// and top to bottom.
+type Grid struct {
+	SheetWidth, SheetHeight float64
+	Cols, Rows              int
+	// Margin is the blank border around the grid.
+	Margin float64
+	// GutterX and GutterY are the gaps between columns and between rows.
+	GutterX, GutterY float64
+	// NoScale places the templates at their natural size instead of scaling
+	// them to fit their cell. They are still centered.
+	NoScale bool
+}
+
+// Impose places tpls on as many sheets as needed. Each template is scaled to
+// fit its cell, keeping its aspect ratio, and centered in it. Blank entries
+// leave their cell empty.
+func (g Grid) Impose(imp *gofpdi.Importer, tpls []int) ([]Sheet, error) {
+	if g.Cols < 1 || g.Rows < 1 {
+		return nil, fmt.Errorf("imposition: grid needs at least one column and row, have %dx%d", g.Cols, g.Rows)
+	}
+	cellW := (g.SheetWidth - 2*g.Margin - float64(g.Cols-1)*g.GutterX) / float64(g.Cols)
+	cellH := (g.SheetHeight - 2*g.Margin - float64(g.Rows-1)*g.GutterY) / float64(g.Rows)
+	if cellW <= 0 || cellH <= 0 {
+		return nil, fmt.Errorf("imposition: margins and gutters leave no room on a %gx%g sheet", g.SheetWidth, g.SheetHeight)
+	}
+	perSheet := g.Cols * g.Rows
+	var sheets []Sheet
+	for i, tpl := range tpls {
+		if i%perSheet == 0 {
+			sheets = append(sheets, Sheet{Width: g.SheetWidth, Height: g.SheetHeight})
+		}
+		if tpl == Blank {
+			continue
+		}
+		slot := i % perSheet
+		col, row := slot%g.Cols, slot/g.Cols
+		cell := [4]float64{
+			g.Margin + float64(col)*(cellW+g.GutterX),
+			g.SheetHeight - g.Margin - float64(row+1)*cellH - float64(row)*g.GutterY,
+		}
+		cell[2], cell[3] = cell[0]+cellW, cell[1]+cellH
+		m, err := fit(imp, tpl, cell, 0, 0, g.NoScale)
+		if err != nil {
+			return nil, err
+		}
+		s := &sheets[len(sheets)-1]
+		s.Placements = append(s.Placements, Placement{Template: tpl, Matrix: m})
+	}
+	return sheets, nil
+}
+
+// Booklet is a saddle-stitch layout: sheets are printed on both sides with two
+// pages each, nested and folded in the middle. Each side is two pages wide.
+type Booklet struct {
+	SheetWidth, SheetHeight float64
+	// Margin is the blank border around the outer edges of the sheet.
+	Margin float64
+	// Gutter is the gap between the two pages of a side, centered on the
+	// fold.
+	Gutter float64
+	// Creep compensates for the sheets pushed outward by the ones folded
+	// around them: the pages of the n-th sheet from the outside (n counted
+	// from 0) are moved n × Creep towards the fold.
+	Creep float64
+	// NoScale places the pages at their natural size instead of scaling them
+	// to fit their half of the sheet.
+	NoScale bool
+}
+
+// Impose places tpls, in reading order, on the sides of a booklet. The page
+// count is padded with blank pages to a multiple of four. The sides are
+// returned in printing order: front and back of the outermost sheet first.
+// Pages are aligned to the fold and centered vertically.
+func (bk Booklet) Impose(imp *gofpdi.Importer, tpls []int) ([]Sheet, error) {
+	halfW := (bk.SheetWidth - 2*bk.Margin - bk.Gutter) / 2
+	cellH := bk.SheetHeight - 2*bk.Margin
+	if halfW <= 0 || cellH <= 0 {
+		return nil, fmt.Errorf("imposition: margins and gutter leave no room on a %gx%g sheet", bk.SheetWidth, bk.SheetHeight)
+	}
+	pages := slices.Clone(tpls)
+	for len(pages)%4 != 0 {
+		pages = append(pages, Blank)
+	}
+	n := len(pages)
+	left := [4]float64{bk.Margin, bk.Margin, bk.Margin + halfW, bk.Margin + cellH}
+	right := [4]float64{bk.SheetWidth - bk.Margin - halfW, bk.Margin, bk.SheetWidth - bk.Margin, bk.Margin + cellH}
+
+	var sheets []Sheet
+	for i := range n / 4 {
+		creep := float64(i) * bk.Creep
+		// Front: last and first page of the sheet's fold; back: the two
+		// inside pages.
+		for _, side := range [2][2]int{{n - 1 - 2*i, 2 * i}, {2*i + 1, n - 2 - 2*i}} {
+			s := Sheet{Width: bk.SheetWidth, Height: bk.SheetHeight}
+			for j, pg := range side {
+				tpl := pages[pg]
+				if tpl == Blank {
+					continue
+				}
+				// The left page is aligned to its right edge (the fold) and
+				// moved right by the creep, the right page the other way.
+				cell, align, shift := left, 1.0, creep
+				if j == 1 {
+					cell, align, shift = right, -1, -creep
+				}
+				m, err := fit(imp, tpl, cell, align, shift, bk.NoScale)
+				if err != nil {
+					return nil, err
+				}
+				s.Placements = append(s.Placements, Placement{Template: tpl, Matrix: m})
+			}
+			sheets = append(sheets, s)
+		}
+	}
+	return sheets, nil
+}
+
+// fit returns the matrix that places template tpl in cell. The template is
+// scaled to fit unless noScale is set, centered vertically, and aligned
+// horizontally to the cell's left edge (align < 0), center (0) or right edge
+// (align > 0), then moved by shift along the x axis.
+func fit(imp *gofpdi.Importer, tpl int, cell [4]float64, align, shift float64, noScale bool) (gofpdi.Matrix, error) {
+	w, h, origin, err := imp.GetTemplateExtent(tpl)
+	if err != nil {
+		return gofpdi.Matrix{}, err
+	}
+	if w <= 0 || h <= 0 {
+		return gofpdi.Matrix{}, fmt.Errorf("imposition: template %d has an empty extent", tpl)
+	}
+	cellW, cellH := cell[2]-cell[0], cell[3]-cell[1]
+	s := 1.0
+	if !noScale {
+		s = math.Min(cellW/w, cellH/h)
+	}
+	x := cell[0] + (cellW-w*s)/2
+	switch {
+	case align < 0:
+		x = cell[0]
+	case align > 0:
+		x = cell[2] - w*s
+	}
+	y := cell[1] + (cellH-h*s)/2
+	return origin.Multiply(gofpdi.Matrix{s, 0, 0, s, x + shift, y}), nil
+}
@@ -0,0 +1,106 @@
+package imposition
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/gofpdi"
+)
+
+// importSample imports both pages of sample.pdf (A4 portrait) into doc.
+func importSample(t *testing.T, doc *gofpdi.Document) (*gofpdi.Importer, []int) {
+	t.Helper()
+	f, err := os.Open(filepath.Join("..", "testdata", "sample.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	imp := doc.Importer()
+	if err := imp.SetSourceStream(f); err != nil {
+		t.Fatal(err)
+	}
+	var tpls []int
+	for p := 1; p <= 2; p++ {
+		tpl, err := imp.ImportPage(p, "/MediaBox")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tpls = append(tpls, tpl)
+	}
+	return imp, tpls
+}
+
+func TestGrid(t *testing.T) {
+	doc := gofpdi.NewDocument()
+	imp, tpls := importSample(t, doc)
+	grid := Grid{SheetWidth: 842, SheetHeight: 595, Cols: 2, Rows: 1, Margin: 10, GutterX: 20}
+	sheets, err := grid.Impose(imp, []int{tpls[0], tpls[1], Blank, tpls[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sheets) != 2 || len(sheets[0].Placements) != 2 || len(sheets[1].Placements) != 1 {
+		t.Fatalf("got %d sheets: %+v", len(sheets), sheets)
+	}
+	// Cells are 401x575; A4 scales by 401/595 and is centered vertically.
+	got := string(sheets[0].Content())
+	want := "q 0.67395 0 0 0.67395 10 13.76723 cm /GOFPDITPL0 Do Q\n"
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("Content() =\n%s\nwant prefix %q", got, want)
+	}
+	if x := sheets[0].Placements[1].Matrix[4]; x < 431 || x > 842-10 {
+		t.Errorf("second column starts at x=%g", x)
+	}
+	// The blank cell is left empty, so the template goes to the right.
+	if x := sheets[1].Placements[0].Matrix[4]; x < 431 {
+		t.Errorf("placement after blank at x=%g, want right column", x)
+	}
+	if names := sheets[0].XObjects(); len(names) != 2 || names[1] != "/GOFPDITPL1" {
+		t.Errorf("XObjects() = %v", names)
+	}
+
+	if _, err := AddSheets(doc, sheets); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, err := doc.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(out.Bytes(), []byte("/MediaBox [0 0 842 595]")); n != 2 {
+		t.Errorf("%d landscape pages, want 2", n)
+	}
+
+	if _, err := (Grid{SheetWidth: 100, SheetHeight: 100, Cols: 2, Rows: 2, Margin: 50}).Impose(imp, tpls); err == nil {
+		t.Error("grid without room succeeded")
+	}
+}
+
+func TestBooklet(t *testing.T) {
+	doc := gofpdi.NewDocument()
+	imp, tpls := importSample(t, doc)
+	// Six pages: padded to eight, two sheets, four sides.
+	pages := []int{tpls[0], tpls[1], tpls[0], tpls[1], tpls[0], tpls[1]}
+	bk := Booklet{SheetWidth: 1190, SheetHeight: 842, Gutter: 0, Creep: 2}
+	sheets, err := bk.Impose(imp, pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sheets) != 4 {
+		t.Fatalf("got %d sides, want 4", len(sheets))
+	}
+	// Outer front: blank page 8 left, page 1 right; outer back: pages 2 and
+	// 7 (blank).
+	if p := sheets[0].Placements; len(p) != 1 || p[0].Template != tpls[0] || p[0].Matrix[4] != 595 {
+		t.Errorf("outer front: %+v", p)
+	}
+	if p := sheets[1].Placements; len(p) != 1 || p[0].Template != tpls[1] || p[0].Matrix[4] != 0 {
+		t.Errorf("outer back: %+v", p)
+	}
+	// Inner sheet: pages 6|3 and 4|5, moved 2pt towards the fold.
+	front := sheets[2].Placements
+	if len(front) != 2 || front[0].Template != tpls[1] || front[0].Matrix[4] != 2 || front[1].Matrix[4] != 593 {
+		t.Errorf("inner front: %+v", front)
+	}
+}
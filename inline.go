@@ -0,0 +1,79 @@
+package gofpdi
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// PageContent is a template exported for splicing into a host page's own
+// content stream instead of being drawn as a Form XObject.
+type PageContent struct {
+	// Content is the uncompressed page content, wrapped in q/Q and preceded
+	// by the template's /Matrix as a cm operator and a clip to its /BBox, so
+	// it draws exactly what the Form XObject would at the host's current
+	// transformation.
+	Content []byte
+	// Resources is the serialized resource dictionary the content needs,
+	// with references to the copied objects. Every resource name is prefixed
+	// with "GOFPDI<n>_", n being the template index, or the prefix given to
+	// WithResourcePrefix, so the entries of several templates can be merged
+	// into the host page's /Resources without collisions.
+	Resources []byte
+}
+
+// putPageContents exports every staged template as page content and copies
+// the objects its resources reference. Annotation sets are written as by
+// PutFormXobjects; structure sets need Form XObjects to point their
+// marked-content references at and are refused, as are template stamps.
+func (pw *PdfWriter) putPageContents() (map[int]PageContent, error) {
+	if len(pw.sources) == 0 {
+		return nil, fmt.Errorf("gofpdi: no source reader")
+	}
+	if len(pw.structSets) > 0 {
+		return nil, fmt.Errorf("gofpdi: ImportStructure needs templates written as Form XObjects")
+	}
+	for i, tpl := range pw.tpls {
+		if tpl.tplStamps {
+			return nil, fmt.Errorf("gofpdi: template %d: template stamps need templates written as Form XObjects", i)
+		}
+	}
+	result := make(map[int]PageContent, len(pw.tpls))
+	for i, tpl := range pw.tpls {
+		// A template staged with WithResourcePrefix already has its names
+		// rewritten.
+		prefix, content := tpl.prefix, tpl.content
+		if prefix == "" {
+			prefix = fmt.Sprintf("GOFPDI%d_", i)
+			var err error
+			if content, err = prefixContent(tpl.content, pw.resourceNames(tpl), prefix); err != nil {
+				return nil, err
+			}
+		}
+
+		pw.source, pw.owner = tpl.source, tpl.resOwner
+		pw.currentObj = new(bytes.Buffer)
+		pw.writeResources(tpl, prefix)
+		if pw.err != nil {
+			return nil, pw.err
+		}
+		res := pw.currentObj.Bytes()
+		if err := pw.drain(); err != nil {
+			return nil, err
+		}
+
+		box := tpl.box
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "q\n%s\n%s %s %s %s re W n\n", tplMatrix(tpl).CM(),
+			formatNumber(box["llx"]), formatNumber(box["lly"]),
+			formatNumber(box["urx"]-box["llx"]), formatNumber(box["ury"]-box["lly"]))
+		b.Write(content)
+		b.WriteString("Q\n")
+		result[i] = PageContent{Content: b.Bytes(), Resources: res}
+	}
+	for _, set := range pw.annotSets {
+		if err := pw.writeAnnotSet(set); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
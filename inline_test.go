@@ -0,0 +1,49 @@
+package gofpdi
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPutPageContents(t *testing.T) {
+	content := "/CS0 cs /DeviceRGB CS /G0 gs /OC /MC0 BDC /Im1 Do EMC\nBI /W 1 /H 1 /CS /CS0 /BPC 8 ID \xff\xff\xff EI\n"
+	pdf := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] /CropBox [10 20 110 70] /Contents 4 0 R" +
+			" /Resources <</XObject <</Im1 5 0 R>> /ColorSpace <</CS0 /DeviceRGB>> /ExtGState <</G0 <</CA 0.5>>>>" +
+			" /Properties <</MC0 <</Type /OCG /Name (L)>>>> /ProcSet [/PDF]>>>>",
+		fmt.Sprintf("<</Length %d>>\nstream\n%s\nendstream", len(content), content),
+		"<</Type /XObject /Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8 /Length 1>>\nstream\n\x00\nendstream",
+	}, "")
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(bytes.NewReader(pdf)); err != nil {
+		t.Fatal(err)
+	}
+	tpl, err := imp.ImportPage(1, "/CropBox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcs, err := imp.PutPageContents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := pcs[tpl]
+	want := "q\n1 0 0 1 -10 -20 cm\n10 20 100 50 re W n\n" +
+		"/GOFPDI0_CS0 cs\n/DeviceRGB CS\n/GOFPDI0_G0 gs\n/OC /GOFPDI0_MC0 BDC\n/GOFPDI0_Im1 Do\nEMC\n" +
+		"BI /BPC 8 /CS /GOFPDI0_CS0 /H 1 /W 1 ID \xff\xff\xff\nEI\nQ\n"
+	if string(pc.Content) != want {
+		t.Errorf("Content =\n%q\nwant\n%q", pc.Content, want)
+	}
+	for _, entry := range []string{"/GOFPDI0_Im1 1 0 R", "/GOFPDI0_CS0 /DeviceRGB", "/GOFPDI0_G0 <<", "/GOFPDI0_MC0 <<", "/ProcSet [/PDF ]"} {
+		if !strings.Contains(string(pc.Resources), entry) {
+			t.Errorf("Resources lack %q:\n%s", entry, pc.Resources)
+		}
+	}
+	if _, ok := imp.GetImportedObjects()[1]; !ok {
+		t.Error("the image was not copied")
+	}
+}
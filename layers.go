@@ -0,0 +1,462 @@
+package gofpdi
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+
+	src "github.com/speedata/pdfdisassembler"
+)
+
+// OCG is a copied optional content group (layer).
+type OCG struct {
+	// ObjID is the output object number of the copy.
+	ObjID int
+	// Name is the layer name shown in viewers.
+	Name string
+}
+
+// Layers describes the optional content of one source as far as imported
+// pages use it, with every group referred to by its output object number.
+type Layers struct {
+	// OCGs are the groups used by imported pages, in order of first use.
+	OCGs []OCG
+	// ByTemplate lists the groups each template of the source uses.
+	ByTemplate map[int][]int
+	// BaseState, On and Off are the initial visibility from the source's
+	// default configuration (/OCProperties /D): BaseState is "ON", "OFF" or
+	// "Unchanged", On and Off the exceptions.
+	BaseState string
+	On, Off   []int
+	// Order is the /Order array of the default configuration as a raw PDF
+	// token, reduced to the copied groups (labels and nesting are kept), or
+	// "" when the source has none.
+	Order string
+	// RBGroups are the radio-button groups of the default configuration,
+	// reduced to the copied groups.
+	RBGroups [][]int
+}
+
+// layers collects the optional content of the given source that the
+// templates written by PutFormXobjects refer to.
+func (pw *PdfWriter) layers(source int) (*Layers, error) {
+	if source < 0 || source >= len(pw.sources) {
+		return nil, fmt.Errorf("gofpdi: unknown source handle %d", source)
+	}
+	r := pw.sources[source]
+	l := &Layers{ByTemplate: make(map[int][]int), BaseState: "ON"}
+	known := make(map[int]bool)
+	for i, tpl := range pw.tpls {
+		if tpl.source != source {
+			continue
+		}
+		for _, ref := range usedOCGs(r, tpl.resources, make(map[*src.Dict]bool)) {
+			id, ok := pw.refMap[sourceRef{source: source, ref: ref}]
+			if !ok {
+				continue // not copied yet: PutFormXobjects has not run
+			}
+			if !known[id] {
+				known[id] = true
+				ocg, _ := resolveIn(r, ref).(*src.Dict)
+				name, _ := pw.crypt(source).text(ocg, "Name", ref)
+				l.OCGs = append(l.OCGs, OCG{ObjID: id, Name: name})
+			}
+			if !slices.Contains(l.ByTemplate[i], id) {
+				l.ByTemplate[i] = append(l.ByTemplate[i], id)
+			}
+		}
+	}
+
+	if _, err := r.Catalog(); err != nil {
+		return nil, err
+	}
+	cat, owner := resolveOwned(r, entry(r.Trailer(), "Root"), src.Reference{})
+	props, owner := resolveOwned(r, entry(asDict(cat), "OCProperties"), owner)
+	obj, owner := resolveOwned(r, entry(asDict(props), "D"), owner)
+	d, ok := obj.(*src.Dict)
+	if !ok {
+		return l, nil
+	}
+	copied := func(obj src.Object) (int, bool) {
+		ref, ok := obj.(src.Reference)
+		if !ok {
+			return 0, false
+		}
+		id, ok := pw.refMap[sourceRef{source: source, ref: ref}]
+		return id, ok && known[id]
+	}
+	ids := func(key string) []int {
+		arr, _ := d.Array(key)
+		var out []int
+		for _, e := range arr {
+			if id, ok := copied(e); ok {
+				out = append(out, id)
+			}
+		}
+		return out
+	}
+	if bs, ok := d.Name("BaseState"); ok {
+		l.BaseState = string(bs)
+	}
+	l.On, l.Off = ids("ON"), ids("OFF")
+	if groups, ok := d.Array("RBGroups"); ok {
+		for _, g := range groups {
+			arr, _ := resolveIn(r, g).(src.Array)
+			var group []int
+			for _, e := range arr {
+				if id, ok := copied(e); ok {
+					group = append(group, id)
+				}
+			}
+			if len(group) > 0 {
+				l.RBGroups = append(l.RBGroups, group)
+			}
+		}
+	}
+	if order, ok := d.Get("Order"); ok {
+		l.Order, _ = orderToken(r, pw.crypt(source), order, owner, copied, 0)
+	}
+	return l, nil
+}
+
+// orderToken serializes an /Order array with only the copied groups. Nested
+// arrays left without groups are dropped; ok reports whether any group
+// remains. c decrypts the labels of an encrypted r; owner is the object obj
+// is part of.
+func orderToken(r *src.Reader, c *sourceCrypt, obj src.Object, owner src.Reference, copied func(src.Object) (int, bool), depth int) (string, bool) {
+	obj, owner = resolveOwned(r, obj, owner)
+	arr, ok := obj.(src.Array)
+	if !ok || depth > 32 {
+		return "", false
+	}
+	var parts []string
+	found := false
+	for _, e := range arr {
+		if id, ok := copied(e); ok {
+			parts = append(parts, fmt.Sprintf("%d 0 R", id))
+			found = true
+			continue
+		}
+		switch v, vOwner := resolveOwned(r, e, owner); v := v.(type) {
+		case src.String:
+			var label bytes.Buffer
+			writeLiteral(&label, c.plain(v, vOwner))
+			parts = append(parts, label.String())
+		case src.Array:
+			if tok, ok := orderToken(r, c, v, vOwner, copied, depth+1); ok {
+				parts = append(parts, tok)
+				found = true
+			}
+		}
+	}
+	return "[" + strings.Join(parts, " ") + "]", found
+}
+
+// usedOCGs returns the optional content groups the resources refer to: in
+// /Properties (directly or through a membership dictionary) and as /OC of
+// XObjects, descending into the resources of nested forms.
+func usedOCGs(r *src.Reader, res *src.Dict, seen map[*src.Dict]bool) []src.Reference {
+	if res == nil || seen[res] {
+		return nil
+	}
+	seen[res] = true
+	var out []src.Reference
+	add := func(obj src.Object) {
+		ref, ok := obj.(src.Reference)
+		if !ok {
+			return
+		}
+		d, ok := resolveIn(r, ref).(*src.Dict)
+		if !ok {
+			return
+		}
+		switch t, _ := d.Name("Type"); t {
+		case "OCG":
+			out = append(out, ref)
+		case "OCMD":
+			ocgs, _ := d.Get("OCGs")
+			if arr, ok := resolveIn(r, ocgs).(src.Array); ok {
+				for _, e := range arr {
+					if ref, ok := e.(src.Reference); ok {
+						out = append(out, ref)
+					}
+				}
+			} else if ref, ok := ocgs.(src.Reference); ok {
+				out = append(out, ref)
+			}
+		}
+	}
+	if props, ok := res.Dict("Properties"); ok {
+		for _, v := range props.Iter() {
+			add(v)
+		}
+	}
+	if xobjs, ok := res.Dict("XObject"); ok {
+		for _, v := range xobjs.Iter() {
+			s, ok := resolveIn(r, v).(*src.Stream)
+			if !ok {
+				continue
+			}
+			if oc, ok := s.Dict.Get("OC"); ok {
+				add(oc)
+			}
+			if inner, ok := s.Dict.Dict("Resources"); ok {
+				out = append(out, usedOCGs(r, inner, seen)...)
+			}
+		}
+	}
+	return out
+}
+
+// hideLayers removes the optional content of tpl that is invisible when the
+// groups named in hidden are off: marked-content sections tagged
+// /OC /name … EMC whose group or membership dictionary evaluates to hidden,
+// and XObjects painted with Do whose /OC does. Forms with hidden content of
+// their own are drawn inline with it removed.
+func (pw *PdfWriter) hideLayers(tpl *pdfTemplate, hidden map[string]bool) error {
+	ops, err := ParseContent(tpl.content)
+	if err != nil {
+		return err
+	}
+	h := layerHider{pw: pw, tpl: tpl, r: pw.sources[tpl.source], c: pw.crypt(tpl.source)}
+	h.v = ocVisibility{r: h.r, c: h.c, hidden: hidden}
+	out, removed, err := h.hide(ops, tpl.resources, tpl.resOwner, 0)
+	if err != nil {
+		return err
+	}
+	if removed {
+		tpl.content = SerializeContent(out)
+	}
+	return nil
+}
+
+// layerHider removes hidden optional content from one template.
+type layerHider struct {
+	pw    *PdfWriter
+	tpl   *pdfTemplate
+	r     *src.Reader
+	c     *sourceCrypt
+	v     ocVisibility
+	forms int // Form XObjects drawn inline, numbering their resource prefixes
+}
+
+// hide returns ops without their hidden content; res, held by resOwner, are
+// the resources ops use. removed reports whether anything was.
+func (h *layerHider) hide(ops []ContentOp, res *src.Dict, resOwner src.Reference, depth int) (out []ContentOp, removed bool, err error) {
+	props, _ := res.Dict("Properties")
+	xobjs, _ := res.Dict("XObject")
+	skip := 0 // nesting depth inside a hidden section
+	for _, op := range ops {
+		if skip > 0 {
+			switch op.Operator {
+			case "BMC", "BDC":
+				skip++
+			case "EMC":
+				skip--
+			}
+			continue
+		}
+		switch op.Operator {
+		case "BDC":
+			if len(op.Operands) == 2 && op.Operands[0].Name == "OC" && op.Operands[1].Kind == OperandName {
+				oc, _ := props.Get(op.Operands[1].Name)
+				if !h.v.visible(oc, 0) {
+					skip = 1
+					removed = true
+					continue
+				}
+			}
+		case "Do":
+			if len(op.Operands) != 1 {
+				break
+			}
+			xobj, _ := xobjs.Get(op.Operands[0].Name)
+			s, ok := resolveIn(h.r, xobj).(*src.Stream)
+			if !ok {
+				break
+			}
+			if oc, ok := s.Dict.Get("OC"); ok && !h.v.visible(oc, 0) {
+				removed = true
+				continue
+			}
+			ref, _ := xobj.(src.Reference)
+			inner, ok, err := h.form(s, ref, res, resOwner, depth)
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				out = append(out, inner...)
+				removed = true
+				continue
+			}
+		}
+		out = append(out, op)
+	}
+	return out, removed, nil
+}
+
+// form returns the content of the Form XObject s, the object ref, with its
+// hidden content removed, ready to replace the Do painting it. ok is false
+// when s is no form or hides nothing. res, held by resOwner, are the
+// resources of the content painting s, which a form without resources of its
+// own uses.
+func (h *layerHider) form(s *src.Stream, ref src.Reference, res *src.Dict, resOwner src.Reference, depth int) (inline []ContentOp, ok bool, err error) {
+	if sub, _ := s.Dict.Name("Subtype"); sub != "Form" || depth >= maxRedactDepth {
+		return nil, false, nil
+	}
+	bbox, ok := dictNumbers(h.r, s.Dict, "BBox")
+	if !ok || len(bbox) != 4 {
+		return nil, false, nil
+	}
+	content, err := h.c.content(s, ref)
+	if err != nil {
+		return nil, false, fmt.Errorf("gofpdi: read form content: %w", err)
+	}
+	ops, err := ParseContent(content)
+	if err != nil {
+		return nil, false, err
+	}
+	obj, owner := resolveOwned(h.r, entry(s.Dict, "Resources"), ref)
+	formRes, own := obj.(*src.Dict)
+	if own {
+		res, resOwner = formRes, owner
+	}
+	ops, removed, err := h.hide(ops, res, resOwner, depth+1)
+	if err != nil || !removed {
+		return nil, false, err
+	}
+	if own {
+		// The form's resources join the template's under a prefix of their
+		// own, as for redaction.
+		prefix := fmt.Sprintf("GOFPDIL%d_", h.forms)
+		h.forms++
+		names := make(map[string]map[string]bool)
+		for category, v := range formRes.Iter() {
+			obj, owner := resolveOwned(h.r, v, resOwner)
+			sub, ok := obj.(*src.Dict)
+			if !ok || !resourceCategories[category] {
+				continue
+			}
+			names[category] = make(map[string]bool)
+			for name, obj := range sub.Iter() {
+				names[category][name] = true
+				h.tpl.extraRes = append(h.tpl.extraRes, resEntry{category: category, name: prefix + name, obj: obj, owner: owner})
+			}
+		}
+		prefixed, err := prefixContent(SerializeContent(ops), names, prefix)
+		if err != nil {
+			return nil, false, err
+		}
+		if ops, err = ParseContent(prefixed); err != nil {
+			return nil, false, err
+		}
+	}
+	box := normalizeRect([4]float64(bbox))
+	fm := IdentityMatrix
+	if m, ok := dictNumbers(h.r, s.Dict, "Matrix"); ok && len(m) == 6 {
+		fm = Matrix(m)
+	}
+	inline = []ContentOp{
+		{Operator: "q"},
+		{Operator: "cm", Operands: numberOperands(fm[:]...)},
+		{Operator: "re", Operands: numberOperands(box[0], box[1], box[2]-box[0], box[3]-box[1])},
+		{Operator: "W"},
+		{Operator: "n"},
+	}
+	inline = append(inline, ops...)
+	return append(inline, ContentOp{Operator: "Q"}), true, nil
+}
+
+// ocVisibility evaluates optional content with the named groups turned off
+// and every other group on.
+type ocVisibility struct {
+	r      *src.Reader
+	c      *sourceCrypt
+	hidden map[string]bool
+}
+
+// visible evaluates an optional content group or membership dictionary
+// (PDF 32000-1 §8.11.2). Anything else counts as visible.
+func (v ocVisibility) visible(oc src.Object, depth int) bool {
+	// Groups are indirect objects, the owners of their names.
+	obj, owner := resolveOwned(v.r, oc, src.Reference{})
+	d, ok := obj.(*src.Dict)
+	if !ok || depth > 32 {
+		return true
+	}
+	switch t, _ := d.Name("Type"); t {
+	case "OCG":
+		name, _ := v.c.text(d, "Name", owner)
+		return !v.hidden[name]
+	case "OCMD":
+		if ve, ok := d.Get("VE"); ok {
+			return v.expression(ve, depth+1)
+		}
+		var groups src.Array
+		switch ocgs := resolveIn(v.r, entry(d, "OCGs")).(type) {
+		case src.Array:
+			groups = ocgs
+		case *src.Dict:
+			groups = src.Array{entry(d, "OCGs")}
+		}
+		on, off := 0, 0
+		for _, g := range groups {
+			if v.visible(g, depth+1) {
+				on++
+			} else {
+				off++
+			}
+		}
+		if len(groups) == 0 {
+			return true
+		}
+		switch p, _ := d.Name("P"); p {
+		case "AllOn":
+			return off == 0
+		case "AnyOff":
+			return off > 0
+		case "AllOff":
+			return on == 0
+		default: // AnyOn
+			return on > 0
+		}
+	}
+	return true
+}
+
+// expression evaluates a visibility expression: an OCG, or an array
+// [/And|/Or|/Not operand…].
+func (v ocVisibility) expression(ve src.Object, depth int) bool {
+	arr, ok := resolveIn(v.r, ve).(src.Array)
+	if !ok || len(arr) == 0 || depth > 32 {
+		return v.visible(ve, depth)
+	}
+	op, _ := resolveIn(v.r, arr[0]).(src.Name)
+	args := arr[1:]
+	switch op {
+	case "Not":
+		return len(args) == 0 || !v.expression(args[0], depth+1)
+	case "And":
+		for _, a := range args {
+			if !v.expression(a, depth+1) {
+				return false
+			}
+		}
+		return true
+	case "Or":
+		for _, a := range args {
+			if v.expression(a, depth+1) {
+				return true
+			}
+		}
+		return len(args) == 0
+	}
+	return true
+}
+
+// entry returns the entry key of d, or nil.
+func entry(d *src.Dict, key string) src.Object {
+	v, _ := d.Get(key)
+	return v
+}
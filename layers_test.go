@@ -0,0 +1,140 @@
+package gofpdi
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestGetLayers(t *testing.T) {
+	pdf := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R /OCProperties <</OCGs [5 0 R 6 0 R 7 0 R] /D <</ON [5 0 R] /OFF [6 0 R 7 0 R]" +
+			" /Order [5 0 R (Group) [6 0 R 7 0 R] [(Empty) 7 0 R]] /RBGroups [[6 0 R 7 0 R] [7 0 R]]>>>>>>",
+		"<</Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /MediaBox [0 0 100 100]>>",
+		"<</Type /Page /Parent 2 0 R /Resources <</Properties <</L0 5 0 R /L1 8 0 R>>>>>>",
+		"<</Type /Page /Parent 2 0 R /Resources <</Properties <</L0 5 0 R>>>>>>",
+		"<</Type /OCG /Name (Drawing)>>",
+		"<</Type /OCG /Name (Notes)>>",
+		"<</Type /OCG /Name (Unused)>>",
+		"<</Type /OCMD /OCGs [6 0 R] /P /AllOn>>",
+	}, "")
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	imp.SetDeduplicate(true)
+	if err := imp.SetSourceStream(bytes.NewReader(pdf)); err != nil {
+		t.Fatal(err)
+	}
+	for p := 1; p <= 2; p++ {
+		if _, err := imp.ImportPage(p, "/MediaBox"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := imp.PutFormXobjects(); err != nil {
+		t.Fatal(err)
+	}
+	l, err := imp.GetLayers(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.OCGs) != 2 || l.OCGs[0].Name != "Drawing" || l.OCGs[1].Name != "Notes" {
+		t.Fatalf("OCGs = %+v", l.OCGs)
+	}
+	drawing, notes := l.OCGs[0].ObjID, l.OCGs[1].ObjID
+	if want := map[int][]int{0: {drawing, notes}, 1: {drawing}}; !reflect.DeepEqual(l.ByTemplate, want) {
+		t.Errorf("ByTemplate = %v, want %v", l.ByTemplate, want)
+	}
+	if l.BaseState != "ON" || !reflect.DeepEqual(l.On, []int{drawing}) || !reflect.DeepEqual(l.Off, []int{notes}) {
+		t.Errorf("visibility = %s %v %v", l.BaseState, l.On, l.Off)
+	}
+	if want := fmt.Sprintf("[%d 0 R (Group) [%d 0 R]]", drawing, notes); l.Order != want {
+		t.Errorf("Order = %s, want %s", l.Order, want)
+	}
+	if !reflect.DeepEqual(l.RBGroups, [][]int{{notes}}) {
+		t.Errorf("RBGroups = %v", l.RBGroups)
+	}
+}
+
+func TestImportHiddenLayers(t *testing.T) {
+	content := "/OC /L0 BDC (draw) Tj EMC\n" +
+		"/OC /L1 BDC (note) Tj /Span BMC (nested) Tj EMC EMC\n" +
+		"/OC /L2 BDC (either) Tj EMC\n" +
+		"/OC /L3 BDC (both) Tj EMC\n" +
+		"/Im0 Do /Im1 Do\n"
+	pdf := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 100 100] /Contents 4 0 R /Resources <<" +
+			"/Properties <</L0 5 0 R /L1 6 0 R /L2 <</Type /OCMD /OCGs [5 0 R 6 0 R]>> /L3 <</Type /OCMD /VE [/And 5 0 R 6 0 R]>>>>" +
+			" /XObject <</Im0 7 0 R /Im1 8 0 R>>>>>>",
+		fmt.Sprintf("<</Length %d>>\nstream\n%s\nendstream", len(content), content),
+		"<</Type /OCG /Name (Drawing)>>",
+		"<</Type /OCG /Name (Notes)>>",
+		"<</Type /XObject /Subtype /Form /BBox [0 0 1 1] /OC 6 0 R /Length 0>>\nstream\n\nendstream",
+		"<</Type /XObject /Subtype /Form /BBox [0 0 1 1] /Length 0>>\nstream\n\nendstream",
+	}, "")
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(bytes.NewReader(pdf)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := imp.ImportPage(1, "/MediaBox", WithHiddenLayers("Notes")); err != nil {
+		t.Fatal(err)
+	}
+	names, err := imp.PutFormXobjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, got := formContent(t, imp.GetImportedObjects()[names["/GOFPDITPL0"]])
+	for _, keep := range []string{"(draw) Tj", "(either) Tj", "/Im1 Do"} {
+		if !bytes.Contains(got, []byte(keep)) {
+			t.Errorf("visible content %q removed:\n%s", keep, got)
+		}
+	}
+	for _, drop := range []string{"(note)", "(nested)", "(both)", "/Im0 Do"} {
+		if bytes.Contains(got, []byte(drop)) {
+			t.Errorf("hidden content %q kept:\n%s", drop, got)
+		}
+	}
+}
+
+func TestImportHiddenLayersNested(t *testing.T) {
+	// Fm1 has resources of its own and paints Fm2, which uses them; both
+	// hide a section of the Notes layer. Fm3 hides nothing and stays a Do.
+	page := "/Fm1 Do /Fm3 Do"
+	fm1 := "/OC /L0 BDC (note) Tj EMC (outer) Tj /Fm2 Do"
+	fm2 := "/OC /L0 BDC (deep) Tj EMC (inner) Tj"
+	pdf := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 100 100] /Contents 4 0 R /Resources <</XObject <</Fm1 5 0 R /Fm3 8 0 R>>>>>>",
+		fmt.Sprintf("<</Length %d>>\nstream\n%s\nendstream", len(page), page),
+		fmt.Sprintf("<</Type /XObject /Subtype /Form /BBox [0 0 50 50] /Matrix [1 0 0 1 10 20] /Resources <</Properties <</L0 7 0 R>> /XObject <</Fm2 6 0 R>>>> /Length %d>>\nstream\n%s\nendstream", len(fm1), fm1),
+		fmt.Sprintf("<</Type /XObject /Subtype /Form /BBox [0 0 50 50] /Length %d>>\nstream\n%s\nendstream", len(fm2), fm2),
+		"<</Type /OCG /Name (Notes)>>",
+		"<</Type /XObject /Subtype /Form /BBox [0 0 1 1] /Length 0>>\nstream\n\nendstream",
+	}, "")
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(bytes.NewReader(pdf)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := imp.ImportPage(1, "/MediaBox", WithHiddenLayers("Notes")); err != nil {
+		t.Fatal(err)
+	}
+	names, err := imp.PutFormXobjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, got := formContent(t, imp.GetImportedObjects()[names["/GOFPDITPL0"]])
+	for _, keep := range []string{"1 0 0 1 10 20 cm", "(outer) Tj", "(inner) Tj", "/Fm3 Do"} {
+		if !bytes.Contains(got, []byte(keep)) {
+			t.Errorf("visible content %q removed:\n%s", keep, got)
+		}
+	}
+	for _, drop := range []string{"(note)", "(deep)", "/Fm1 Do", "/Fm2 Do"} {
+		if bytes.Contains(got, []byte(drop)) {
+			t.Errorf("hidden content %q kept:\n%s", drop, got)
+		}
+	}
+}
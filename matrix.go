@@ -0,0 +1,225 @@
+package gofpdi
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Matrix is a PDF transformation matrix [a b c d e f]. It maps the point
+// (x, y) to (a·x + c·y + e, b·x + d·y + f), the convention of the cm operator
+// and of a Form XObject's /Matrix.
+type Matrix [6]float64
+
+// IdentityMatrix leaves coordinates unchanged.
+var IdentityMatrix = Matrix{1, 0, 0, 1, 0, 0}
+
+// Multiply returns the transformation that applies m first and n second (the
+// product m × n in PDF's row-vector notation).
+func (m Matrix) Multiply(n Matrix) Matrix {
+	return Matrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+// Apply transforms the point (x, y).
+func (m Matrix) Apply(x, y float64) (float64, float64) {
+	return m[0]*x + m[2]*y + m[4], m[1]*x + m[3]*y + m[5]
+}
+
+// invert returns the transformation undoing m; ok is false when m is
+// singular.
+func (m Matrix) invert() (Matrix, bool) {
+	det := m[0]*m[3] - m[1]*m[2]
+	if det == 0 {
+		return Matrix{}, false
+	}
+	a, b, c, d := m[3]/det, -m[1]/det, -m[2]/det, m[0]/det
+	return Matrix{a, b, c, d, -(m[4]*a + m[5]*c), -(m[4]*b + m[5]*d)}, true
+}
+
+// transformRect returns the axis-aligned bounding box of the rectangle
+// [llx lly urx ury] after transformation by m.
+func (m Matrix) transformRect(r [4]float64) [4]float64 {
+	out := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, p := range [][2]float64{{r[0], r[1]}, {r[2], r[1]}, {r[0], r[3]}, {r[2], r[3]}} {
+		x, y := m.Apply(p[0], p[1])
+		out[0], out[1] = math.Min(out[0], x), math.Min(out[1], y)
+		out[2], out[3] = math.Max(out[2], x), math.Max(out[3], y)
+	}
+	return out
+}
+
+// tplMatrix returns the Form XObject /Matrix of tpl: the one computed by
+// formMatrix, followed by the user transformation of the import options.
+func tplMatrix(tpl *pdfTemplate) Matrix {
+	c, s, tx, ty := formMatrix(tpl)
+	m := Matrix{c, s, -s, c, tx, ty}
+	if tpl.transform != (Matrix{}) {
+		m = m.Multiply(tpl.transform)
+	}
+	return m
+}
+
+// userTransform returns the transformation that applies the mirroring,
+// rotation and scaling options of cfg to a template drawn by base with the
+// given /BBox, and moves the result back to the origin. ok is false when cfg
+// has none of these options.
+func (cfg importConfig) userTransform(base Matrix, bbox [4]float64) (m Matrix, ok bool, err error) {
+	if !cfg.mirrorH && !cfg.mirrorV && cfg.rotate == 0 && cfg.scale == nil && cfg.size == nil {
+		return Matrix{}, false, nil
+	}
+	m = IdentityMatrix
+	if cfg.mirrorH {
+		m[0] = -1
+	}
+	if cfg.mirrorV {
+		m[3] = -1
+	}
+	m = m.Multiply(rotation(-cfg.rotate)) // clockwise, like /Rotate
+
+	sx, sy := 1.0, 1.0
+	switch {
+	case cfg.scale != nil:
+		sx, sy = cfg.scale[0], cfg.scale[1]
+		if sx == 0 || sy == 0 {
+			return Matrix{}, false, fmt.Errorf("gofpdi: scale factors must not be zero, have %g and %g", sx, sy)
+		}
+	case cfg.size != nil:
+		w, h := cfg.size[0], cfg.size[1]
+		if w < 0 || h < 0 || w == 0 && h == 0 {
+			return Matrix{}, false, fmt.Errorf("gofpdi: invalid target size %gx%g", w, h)
+		}
+		r := base.Multiply(m).transformRect(bbox)
+		if r[2] == r[0] || r[3] == r[1] {
+			return Matrix{}, false, fmt.Errorf("gofpdi: cannot scale an empty template")
+		}
+		sx, sy = w/(r[2]-r[0]), h/(r[3]-r[1])
+		if w == 0 {
+			sx = sy
+		} else if h == 0 {
+			sy = sx
+		}
+	}
+	m = m.Multiply(Matrix{sx, 0, 0, sy, 0, 0})
+
+	r := base.Multiply(m).transformRect(bbox)
+	return m.Multiply(Matrix{1, 0, 0, 1, -r[0], -r[1]}), true, nil
+}
+
+// rotation returns the counterclockwise rotation by deg degrees, exact for
+// multiples of 90.
+func rotation(deg float64) Matrix {
+	deg = math.Mod(deg, 360)
+	var c, s float64
+	switch deg {
+	case 0:
+		c = 1
+	case 90, -270:
+		s = 1
+	case 180, -180:
+		c = -1
+	case 270, -90:
+		s = -1
+	default:
+		rad := deg * math.Pi / 180
+		c, s = math.Cos(rad), math.Sin(rad)
+	}
+	return Matrix{c, s, -s, c, 0, 0}
+}
+
+// templateExtent returns the size of template tplN as drawn, its /BBox
+// transformed by its /Matrix (including the user transformation), and the
+// translation that moves the drawn area to the origin.
+func (pw *PdfWriter) templateExtent(tplN int) (w, h float64, origin Matrix, err error) {
+	if tplN < 0 || tplN >= len(pw.tpls) {
+		return 0, 0, Matrix{}, fmt.Errorf("gofpdi: unknown template %d", tplN)
+	}
+	tpl := pw.tpls[tplN]
+	r := tplMatrix(tpl).transformRect([4]float64{tpl.box["llx"], tpl.box["lly"], tpl.box["urx"], tpl.box["ury"]})
+	return r[2] - r[0], r[3] - r[1], Matrix{1, 0, 0, 1, -r[0], -r[1]}, nil
+}
+
+// TemplateInfo describes the geometry of a staged template.
+type TemplateInfo struct {
+	// Source and Page are the source handle and 1-based page number the
+	// template was imported from.
+	Source, Page int
+	// Box is the page box the template shows ("/MediaBox", "/TrimBox", …),
+	// or "" for a WithClip rectangle.
+	Box string
+	// BBox is the Form XObject /BBox [llx lly urx ury], in the page's default
+	// user space.
+	BBox [4]float64
+	// Matrix is the Form XObject /Matrix: box translation, page rotation and
+	// the transformation options of the import.
+	Matrix Matrix
+	// Width and Height are the size of the template as drawn, after rotation
+	// and scaling.
+	Width, Height float64
+	// Rotation is the clockwise rotation in degrees [0, 360) the template
+	// applies to the page content: the page /Rotate, where formMatrix honours
+	// it, plus WithRotation.
+	Rotation float64
+}
+
+// templateInfo returns the geometry of template tplN.
+func (pw *PdfWriter) templateInfo(tplN int) (TemplateInfo, error) {
+	w, h, _, err := pw.templateExtent(tplN)
+	if err != nil {
+		return TemplateInfo{}, err
+	}
+	tpl := pw.tpls[tplN]
+	box := tpl.box
+	rot := tpl.rotate
+	if c, s, _, _ := formMatrix(tpl); c != 1 || s != 0 {
+		rot -= float64(tpl.rotation) // formMatrix counter-rotated the page
+	}
+	return TemplateInfo{
+		Source:   tpl.source,
+		Page:     tpl.page.Index() + 1,
+		Box:      tpl.boxName,
+		BBox:     [4]float64{box["llx"], box["lly"], box["urx"], box["ury"]},
+		Matrix:   tplMatrix(tpl),
+		Width:    w,
+		Height:   h,
+		Rotation: math.Mod(math.Mod(rot, 360)+360, 360),
+	}, nil
+}
+
+// mapRect returns the matrix that maps rectangle from onto rectangle to,
+// scaling each axis independently. ok is false when from is degenerate.
+func mapRect(from, to [4]float64) (Matrix, bool) {
+	w, h := from[2]-from[0], from[3]-from[1]
+	if w == 0 || h == 0 {
+		return Matrix{}, false
+	}
+	sx, sy := (to[2]-to[0])/w, (to[3]-to[1])/h
+	return Matrix{sx, 0, 0, sy, to[0] - from[0]*sx, to[1] - from[1]*sy}, true
+}
+
+// CM returns the content stream operator "a b c d e f cm" that concatenates
+// m to the CTM, with the numbers rounded to five decimals.
+func (m Matrix) CM() string {
+	s := make([]byte, 0, 64)
+	for _, f := range m {
+		s = append(s, formatNumber(f)...)
+		s = append(s, ' ')
+	}
+	return string(s) + "cm"
+}
+
+// formatNumber formats a computed number for a content stream, rounded to
+// five decimals.
+func formatNumber(f float64) string {
+	s := strconv.FormatFloat(math.Round(f*1e5)/1e5, 'f', -1, 64)
+	if s == "-0" {
+		s = "0"
+	}
+	return s
+}
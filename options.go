@@ -0,0 +1,279 @@
+package gofpdi
+
+import (
+	"fmt"
+	"image"
+	"slices"
+)
+
+// ImportOption changes how ImportPage stages a page.
+type ImportOption func(*importConfig)
+
+// importConfig collects the ImportOptions of one ImportPage call.
+type importConfig struct {
+	flattenAnnots bool
+	annotsToPrint bool // flatten as printed rather than as shown on screen
+	flattenForms  bool
+	hiddenLayers  map[string]bool
+	clip          *[4]float64
+	rotate        float64     // clockwise degrees
+	scale, size   *[2]float64 // at most one is set
+	mirrorH       bool
+	mirrorV       bool
+	prefix        *string // WithResourcePrefix; "" for the template's own
+	filters       []ContentFilter
+	redactions    [][4]float64 // normalized
+	redactFill    *[3]float64
+	stamps        []stamp
+}
+
+// newImportConfig applies opts to the default configuration.
+func newImportConfig(opts []ImportOption) importConfig {
+	var cfg importConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// key returns a canonical description of cfg: two configurations with equal
+// keys stage a page identically.
+func (cfg importConfig) key() string {
+	hidden := make([]string, 0, len(cfg.hiddenLayers))
+	for name := range cfg.hiddenLayers {
+		hidden = append(hidden, name)
+	}
+	slices.Sort(hidden)
+	key := fmt.Sprintf("annots=%t/%t forms=%t hide=%q", cfg.flattenAnnots, cfg.flattenAnnots && cfg.annotsToPrint, cfg.flattenForms, hidden)
+	if cfg.clip != nil {
+		key += fmt.Sprintf(" clip=%v", normalizeRect(*cfg.clip))
+	}
+	if cfg.rotate != 0 || cfg.mirrorH || cfg.mirrorV {
+		key += fmt.Sprintf(" rotate=%g mirror=%t/%t", cfg.rotate, cfg.mirrorH, cfg.mirrorV)
+	}
+	if cfg.scale != nil {
+		key += fmt.Sprintf(" scale=%v", *cfg.scale)
+	}
+	if cfg.size != nil {
+		key += fmt.Sprintf(" size=%v", *cfg.size)
+	}
+	if cfg.prefix != nil {
+		key += fmt.Sprintf(" prefix=%q", *cfg.prefix)
+	}
+	if len(cfg.redactions) > 0 {
+		key += fmt.Sprintf(" redact=%v", cfg.redactions)
+		if cfg.redactFill != nil {
+			key += fmt.Sprintf(" fill=%v", *cfg.redactFill)
+		}
+	}
+	for _, s := range cfg.stamps {
+		key += " stamp=" + s.key()
+	}
+	return key
+}
+
+// WithFlattenAnnots burns the annotations of the page into the template: the
+// normal appearance stream (/AP /N, or the state selected by /AS) of every
+// annotation is drawn on top of the page content, fitted into the
+// annotation's /Rect as a viewer does. With print set, only annotations
+// flagged for printing are drawn; otherwise those hidden on screen (NoView)
+// are skipped. Hidden annotations and annotations without an appearance
+// stream are never drawn.
+func WithFlattenAnnots(print bool) ImportOption {
+	return func(cfg *importConfig) {
+		cfg.flattenAnnots = true
+		cfg.annotsToPrint = print
+	}
+}
+
+// WithFlattenForms burns the filled-in AcroForm fields of the page into the
+// template, as printed. Widgets are drawn from their appearance streams; text
+// fields and combo boxes without one (or all of them, when the form sets
+// /NeedAppearances) get an appearance generated from the field value, its
+// default appearance string (/DA) and the default resources (/DR), honouring
+// alignment (/Q), multiline and comb fields. Generated text is limited to
+// single-byte fonts; password fields are left blank.
+//
+// WithFlattenForms can be combined with WithFlattenAnnots; on its own, other
+// annotations stay untouched.
+func WithFlattenForms() ImportOption {
+	return func(cfg *importConfig) {
+		cfg.flattenForms = true
+	}
+}
+
+// WithHiddenLayers removes the content of the named optional content groups
+// (layers) from the template, as if they were switched off in a viewer: the
+// marked-content sections tagged /OC whose group, or membership dictionary
+// including visibility expressions, is then invisible, and XObjects whose
+// /OC is. Form XObjects with such content inside are drawn inline without
+// it, nested forms included. All other groups count as switched on.
+func WithHiddenLayers(names ...string) ImportOption {
+	return func(cfg *importConfig) {
+		if cfg.hiddenLayers == nil {
+			cfg.hiddenLayers = make(map[string]bool)
+		}
+		for _, name := range names {
+			cfg.hiddenLayers[name] = true
+		}
+	}
+}
+
+// WithClip cuts the rectangle [llx lly urx ury], given in the page's default
+// user space (before /Rotate), out of the page instead of the box passed to
+// ImportPage. The rectangle is clamped to the MediaBox and must overlap it.
+// The template's /BBox is the rectangle and its /Matrix moves it to the
+// origin, so the template is exactly the size of the cut-out; annotations
+// entirely outside it are not copied by ImportAnnots. The box passed to
+// ImportPage does not matter: the same clip with another box returns the
+// same template.
+func WithClip(llx, lly, urx, ury float64) ImportOption {
+	return func(cfg *importConfig) {
+		cfg.clip = &[4]float64{llx, lly, urx, ury}
+	}
+}
+
+// WithRotation rotates the template clockwise by degrees, which need not be a
+// multiple of 90, on top of the page's own /Rotate. The rotated page is moved
+// back to the origin, so GetTemplateExtent reports its bounding box.
+//
+// Mirroring, rotation and scaling are applied in that order and folded into
+// the template's /Matrix, together with the box translation and the page
+// rotation.
+func WithRotation(degrees float64) ImportOption {
+	return func(cfg *importConfig) {
+		cfg.rotate += degrees
+	}
+}
+
+// WithScale scales the template by sx horizontally and sy vertically (after
+// mirroring and rotation). It replaces an earlier WithSize.
+func WithScale(sx, sy float64) ImportOption {
+	return func(cfg *importConfig) {
+		cfg.scale, cfg.size = &[2]float64{sx, sy}, nil
+	}
+}
+
+// WithSize scales the template, as mirrored and rotated, to width × height
+// points. If one of them is 0, it follows from the other with the aspect
+// ratio kept. It replaces an earlier WithScale.
+func WithSize(width, height float64) ImportOption {
+	return func(cfg *importConfig) {
+		cfg.scale, cfg.size = nil, &[2]float64{width, height}
+	}
+}
+
+// WithMirror flips the template left to right (horizontal) and/or upside down
+// (vertical).
+func WithMirror(horizontal, vertical bool) ImportOption {
+	return func(cfg *importConfig) {
+		cfg.mirrorH = cfg.mirrorH != horizontal
+		cfg.mirrorV = cfg.mirrorV != vertical
+	}
+}
+
+// WithResourcePrefix renames every resource of the template (fonts,
+// XObjects, graphics states, color spaces, patterns, shadings and property
+// lists) by prepending prefix, and rewrites the operands of the content
+// stream that use them, so the template's resources can share one dictionary
+// with the host's or another template's without collisions. An empty prefix
+// selects "GOFPDI<n>_", n being the template index.
+func WithResourcePrefix(prefix string) ImportOption {
+	return func(cfg *importConfig) {
+		cfg.prefix = &prefix
+	}
+}
+
+// WithContentFilter runs every operation of the page content through f
+// before the template is compressed, after layers were hidden and
+// annotations flattened and before resources are renamed. Several filters
+// run in the order given, each on the output of the previous one. The
+// content is parsed with ParseContent and written back with
+// SerializeContent.
+//
+// Filters are arbitrary code, so a page imported with one is always staged
+// as a new template.
+func WithContentFilter(f ContentFilter) ImportOption {
+	return func(cfg *importConfig) {
+		cfg.filters = append(cfg.filters, f)
+	}
+}
+
+// WithRedaction removes what the page draws in the given rectangles
+// [llx lly urx ury], in the page's default user space (before /Rotate), from
+// the template content: glyphs of text whose box touches a rectangle, painted
+// paths and shadings touching one, and the pixels of images, inline or
+// XObject, drawn there. Text on either side keeps its position; marked
+// content around removed glyphs loses its /ActualText, /Alt and /E. Form
+// XObjects touching a rectangle are drawn inline and redacted the same way,
+// and XObjects no longer painted are not copied, so the removed content is
+// not in the output file. Annotations touching a rectangle are not copied by
+// ImportAnnots.
+//
+// Removal is by whole glyph, path and pixel: images are decoded, the pixels
+// touching a rectangle blanked and the image re-encoded with Flate. An image
+// gofpdi cannot decode (DCT, JPX, JBIG2, CCITT fax) touching a rectangle is
+// removed as a whole. Stroked paths count with their line width. Text
+// in a Type 0 font whose CMap is not Identity-H or Identity-V makes the
+// import fail. Redaction runs after content filters. Several calls add
+// rectangles.
+func WithRedaction(rects ...[4]float64) ImportOption {
+	return func(cfg *importConfig) {
+		for _, r := range rects {
+			cfg.redactions = append(cfg.redactions, normalizeRect(r))
+		}
+	}
+}
+
+// WithRedactionFill paints the rectangles of WithRedaction in the RGB color
+// r, g, b (each 0–1) on top of the redacted content.
+func WithRedactionFill(r, g, b float64) ImportOption {
+	return func(cfg *importConfig) {
+		cfg.redactFill = &[3]float64{r, g, b}
+	}
+}
+
+// WithTextStamp draws text over or under the page, e.g. "COPY" or "DRAFT",
+// in font, one of the standard 14 fonts ("Helvetica", "Times-Bold", …), at
+// size points. Text is encoded in WinAnsiEncoding (byte for byte for Symbol
+// and ZapfDingbats); characters it lacks become '?'. s positions the stamp.
+//
+// Stamps are drawn inside the template's Form XObject, in the order given,
+// after redaction and before resources are renamed.
+func WithTextStamp(text, font string, size float64, s Stamp) ImportOption {
+	return func(cfg *importConfig) {
+		cfg.stamps = append(cfg.stamps, stamp{Stamp: s, text: text, font: font, size: size})
+	}
+}
+
+// WithImageStamp draws img over or under the page, width × height points
+// large. If one of them is 0, it follows from the other with the aspect ratio
+// kept; if both are, each pixel is a point. The image is encoded once, when
+// WithImageStamp is called, and written once however many templates the
+// option is passed to.
+func WithImageStamp(img image.Image, width, height float64, s Stamp) ImportOption {
+	g := imageObject(img)
+	px, py := float64(img.Bounds().Dx()), float64(img.Bounds().Dy())
+	switch {
+	case width == 0 && height == 0:
+		width, height = px, py
+	case width == 0 && py > 0:
+		width = height * px / py
+	case height == 0 && px > 0:
+		height = width * py / px
+	}
+	return func(cfg *importConfig) {
+		cfg.stamps = append(cfg.stamps, stamp{Stamp: s, img: g, w: width, h: height})
+	}
+}
+
+// WithTemplateStamp draws template tplN, imported earlier, over or under
+// the page, as /GOFPDITPL<tplN> Do would at the stamp's position. The
+// template is referenced, not copied, so a stamp used on many pages is in the
+// output once. Template stamps need PutFormXobjects; PutPageContents refuses
+// them.
+func WithTemplateStamp(tplN int, s Stamp) ImportOption {
+	return func(cfg *importConfig) {
+		cfg.stamps = append(cfg.stamps, stamp{Stamp: s, template: tplN})
+	}
+}
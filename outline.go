@@ -0,0 +1,112 @@
+package gofpdi
+
+import (
+	"fmt"
+
+	src "github.com/speedata/pdfdisassembler"
+)
+
+// OutlineItem is an entry of a source document outline (bookmarks), with its
+// destination mapped to an imported template.
+type OutlineItem struct {
+	Title string
+	// Color is the RGB text color (/C); black when the source sets none.
+	Color        [3]float64
+	Italic, Bold bool
+	// Open reports whether the item shows its children initially.
+	Open bool
+	// Dest is the destination of the item. It is nil for items kept only
+	// because some of their children point to imported pages.
+	Dest *Destination
+	Kids []*OutlineItem
+}
+
+// Outline item flags (PDF 32000-1 §12.3.3).
+const (
+	outlineItalic = 1 << 0
+	outlineBold   = 1 << 1
+)
+
+// ImportOutlines returns the outline of the source with the given handle,
+// reduced to the entries whose destination (/Dest, or a /GoTo action) is a
+// page imported so far. Named destinations are resolved. An entry pointing
+// elsewhere is kept, without destination, when some of its descendants are
+// kept. Call it after the ImportPage calls; the result is independent of
+// PutFormXobjects.
+//
+// When a page was imported into several templates, the destination refers to
+// the first of them.
+func (imp *Importer) ImportOutlines(source int) ([]*OutlineItem, error) {
+	if source < 0 || source >= len(imp.writer.sources) {
+		return nil, fmt.Errorf("gofpdi: unknown source handle %d", source)
+	}
+	r := imp.writer.sources[source]
+	cat, err := r.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	root, ok := cat.Dict("Outlines")
+	if !ok {
+		return nil, nil
+	}
+	w := outlineWalker{pw: imp.writer, source: source, r: r, seen: make(map[*src.Dict]bool)}
+	return w.children(root, 0), nil
+}
+
+// outlineWalker collects the outline entries of one source.
+type outlineWalker struct {
+	pw     *PdfWriter
+	source int
+	r      *src.Reader
+	seen   map[*src.Dict]bool // guards against /Next and /First cycles
+}
+
+// children returns the kept items among the children of node.
+func (w *outlineWalker) children(node *src.Dict, depth int) []*OutlineItem {
+	if depth > 64 {
+		return nil
+	}
+	var items []*OutlineItem
+	next := entry(node, "First")
+	for {
+		// Outline items are indirect objects; each is the owner of its
+		// strings.
+		ref, _ := next.(src.Reference)
+		child, ok := resolveIn(w.r, next).(*src.Dict)
+		if !ok || w.seen[child] {
+			break
+		}
+		w.seen[child] = true
+		if item := w.item(child, ref, depth); item != nil {
+			items = append(items, item)
+		}
+		next = entry(child, "Next")
+	}
+	return items
+}
+
+// item converts the outline item d, the object ref, or returns nil when
+// neither it nor any of its descendants points to an imported page.
+func (w *outlineWalker) item(d *src.Dict, ref src.Reference, depth int) *OutlineItem {
+	item := &OutlineItem{Kids: w.children(d, depth+1)}
+	dest, owner := entry(d, "Dest"), ref
+	if !d.Has("Dest") {
+		dest, owner = actionDest(w.r, entry(d, "A"), ref)
+	}
+	if rd, ok := w.pw.resolveDest(w.source, dest, owner); ok && rd.Template >= 0 {
+		item.Dest = &rd.Destination
+	}
+	if item.Dest == nil && len(item.Kids) == 0 {
+		return nil
+	}
+	item.Title, _ = w.pw.crypt(w.source).text(d, "Title", ref)
+	if c, ok := dictNumbers(w.r, d, "C"); ok && len(c) == 3 {
+		item.Color = [3]float64(c)
+	}
+	flags, _ := d.Int("F")
+	item.Italic = flags&outlineItalic != 0
+	item.Bold = flags&outlineBold != 0
+	count, _ := d.Int("Count")
+	item.Open = count > 0
+	return item
+}
@@ -0,0 +1,109 @@
+package gofpdi
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	src "github.com/speedata/pdfdisassembler"
+)
+
+func TestImportOutlines(t *testing.T) {
+	pdf := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R /Outlines 6 0 R /Dests <</sec [5 0 R /FitH 50]>> /Names <</Dests 11 0 R>>>>",
+		"<</Type /Pages /Kids [3 0 R 4 0 R 5 0 R] /Count 3 /MediaBox [0 0 200 100]>>",
+		"<</Type /Page /Parent 2 0 R>>",
+		"<</Type /Page /Parent 2 0 R>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [10 10 210 110] /Rotate 90>>",
+		"<</Type /Outlines /First 7 0 R /Last 9 0 R /Count 3>>",
+		"<</Title (Intro) /Parent 6 0 R /Next 8 0 R /Dest [3 0 R /XYZ 10 90 null] /C [1 0 0] /F 2>>",
+		"<</Title (Part) /Parent 6 0 R /Prev 7 0 R /Next 9 0 R /First 10 0 R /Last 10 0 R /Count 1 /A <</S /GoTo /D (chap2)>>>>",
+		"<</Title (Skipped) /Parent 6 0 R /Prev 8 0 R /Dest [4 0 R /Fit]>>",
+		"<</Title (Section) /Parent 8 0 R /Dest /sec>>",
+		"<</Names [(chap2) <</D [4 0 R /Fit]>>]>>",
+	}, "")
+	imp := NewImporter()
+	if err := imp.SetSourceStream(bytes.NewReader(pdf)); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []int{1, 3} {
+		if _, err := imp.ImportPage(p, "/MediaBox"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	items, err := imp.ImportOutlines(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("got %d top-level items, want 2", len(items))
+	}
+	intro, part := items[0], items[1]
+	if intro.Title != "Intro" || !intro.Bold || intro.Italic || intro.Color != [3]float64{1, 0, 0} {
+		t.Errorf("intro = %+v", intro)
+	}
+	if d := intro.Dest; d == nil || d.Template != 0 || d.Fit != "XYZ" || d.Left != 10 || d.Top != 90 || !math.IsNaN(d.Zoom) {
+		t.Errorf("intro destination = %+v", intro.Dest)
+	}
+	// Page 2 is not imported: Part survives as a container for Section.
+	if part.Title != "Part" || part.Dest != nil || !part.Open || len(part.Kids) != 1 {
+		t.Fatalf("part = %+v", part)
+	}
+	// Page 3 is rotated a quarter turn, so the FitH of the source becomes a
+	// FitV in form space.
+	if d := part.Kids[0].Dest; d == nil || d.Template != 1 || d.Fit != "FitV" || d.Left != 40 {
+		t.Errorf("section destination = %+v", d)
+	}
+}
+
+func TestResolveDestRotated(t *testing.T) {
+	// The same box turned a quarter turn either way. An XYZ destination
+	// names the point at the upper left corner of the view; in form space
+	// that is the image of the point, and a null coordinate moves to the
+	// other axis with it.
+	pdf := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /MediaBox [10 10 210 110]>>",
+		"<</Type /Page /Parent 2 0 R /Rotate 90>>",
+		"<</Type /Page /Parent 2 0 R /Rotate 270>>",
+	}, "")
+	imp := NewImporter()
+	if err := imp.SetSourceStream(bytes.NewReader(pdf)); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []int{1, 2} {
+		if _, err := imp.ImportPage(p, "/MediaBox"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nan := math.NaN()
+	for _, tc := range []struct {
+		page      int
+		left, top float64
+		want      [2]float64
+	}{
+		// Rotate 90: the top edge of the page becomes its right edge.
+		{3, 50, 100, [2]float64{90, 160}},
+		{3, nan, 100, [2]float64{90, nan}},
+		{3, 50, nan, [2]float64{nan, 160}},
+		// Rotate 270: the top edge becomes the left edge.
+		{4, 50, 100, [2]float64{10, 40}},
+		{4, nan, 100, [2]float64{10, nan}},
+	} {
+		coord := func(f float64) src.Object {
+			if math.IsNaN(f) {
+				return src.Null{}
+			}
+			return src.Real(f)
+		}
+		dest := src.Array{src.Reference{Number: tc.page}, src.Name("XYZ"), coord(tc.left), coord(tc.top), src.Null{}}
+		rd, err := imp.ResolveDest(0, dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		same := func(a, b float64) bool { return a == b || math.IsNaN(a) && math.IsNaN(b) }
+		if rd.Fit != "XYZ" || !same(rd.Left, tc.want[0]) || !same(rd.Top, tc.want[1]) {
+			t.Errorf("page %d XYZ %v %v = left %v top %v, want %v", tc.page, tc.left, tc.top, rd.Left, rd.Top, tc.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package gofpdi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParsePageRange expands a page range expression for a document of numPages
+// pages into 1-based page numbers, in the order given. The expression is a
+// comma-separated list of
+//
+//   - page numbers, where "end" stands for the last page;
+//   - ranges "a-b"; a range with a > b runs backwards;
+//   - "odd" and "even" for every odd or even page;
+//   - "reverse" for all pages from the last to the first;
+//   - "last-n" for the last n pages.
+//
+// Example: "1-3,7,10-end".
+func ParsePageRange(spec string, numPages int) ([]int, error) {
+	var pages []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		switch part {
+		case "odd", "even":
+			first := 1
+			if part == "even" {
+				first = 2
+			}
+			for p := first; p <= numPages; p += 2 {
+				pages = append(pages, p)
+			}
+			continue
+		case "reverse":
+			for p := numPages; p >= 1; p-- {
+				pages = append(pages, p)
+			}
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		if isRange && strings.TrimSpace(from) == "last" {
+			n, err := strconv.Atoi(strings.TrimSpace(to))
+			if err != nil || n < 1 || n > numPages {
+				return nil, fmt.Errorf("gofpdi: page range: invalid page count in %q for %d pages", part, numPages)
+			}
+			for p := numPages - n + 1; p <= numPages; p++ {
+				pages = append(pages, p)
+			}
+			continue
+		}
+		a, err := pageNumber(from, numPages)
+		if err != nil {
+			return nil, err
+		}
+		b := a
+		if isRange {
+			if b, err = pageNumber(to, numPages); err != nil {
+				return nil, err
+			}
+		}
+		step := 1
+		if b < a {
+			step = -1
+		}
+		for p := a; ; p += step {
+			pages = append(pages, p)
+			if p == b {
+				break
+			}
+		}
+	}
+	return pages, nil
+}
+
+// pageNumber parses one page reference of a range expression.
+func pageNumber(s string, numPages int) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "end" {
+		if numPages < 1 {
+			return 0, fmt.Errorf("gofpdi: page range: document has no pages")
+		}
+		return numPages, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("gofpdi: page range: invalid page %q", s)
+	}
+	if n < 1 || n > numPages {
+		return 0, fmt.Errorf("gofpdi: page range: page %d out of range 1-%d", n, numPages)
+	}
+	return n, nil
+}
@@ -0,0 +1,65 @@
+package gofpdi
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParsePageRange(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want []int
+	}{
+		{"1-3,7,10-end", []int{1, 2, 3, 7, 10, 11, 12}},
+		{"end", []int{12}},
+		{" 4 - 2 , 5", []int{4, 3, 2, 5}},
+		{"odd", []int{1, 3, 5, 7, 9, 11}},
+		{"even,1", []int{2, 4, 6, 8, 10, 12, 1}},
+		{"reverse", []int{12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}},
+		{"last-3", []int{10, 11, 12}},
+	} {
+		got, err := ParsePageRange(tc.spec, 12)
+		if err != nil {
+			t.Errorf("%q: %v", tc.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q = %v, want %v", tc.spec, got, tc.want)
+		}
+	}
+	for _, bad := range []string{"", "0", "13", "1-x", "1,,2", "last-0", "last-13", "last"} {
+		if _, err := ParsePageRange(bad, 12); err == nil {
+			t.Errorf("%q should fail", bad)
+		}
+	}
+}
+
+func TestImportPages(t *testing.T) {
+	r, err := os.Open("testdata/sample.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	imp := NewImporter()
+	if err := imp.SetSourceStream(r); err != nil {
+		t.Fatal(err)
+	}
+	first, err := imp.ImportPage(2, "/MediaBox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpls, reused, err := imp.ImportPages("reverse,odd", "/MediaBox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tpls, []int{first, first + 1, first + 1}) {
+		t.Errorf("templates = %v", tpls)
+	}
+	if !reflect.DeepEqual(reused, []int{2, 1}) {
+		t.Errorf("reused pages = %v, want [2 1]", reused)
+	}
+	if _, _, err := imp.ImportPages("1-3", "/MediaBox"); err == nil {
+		t.Error("range beyond the last page succeeded")
+	}
+}
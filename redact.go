@@ -0,0 +1,1060 @@
+package gofpdi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"math"
+
+	src "github.com/speedata/pdfdisassembler"
+)
+
+// Redaction (WithRedaction) removes what the page draws in the given areas
+// from the template content itself, so that it is gone from the output file
+// rather than hidden under a box. The content is walked with the current
+// transformation matrix and text state tracked:
+//
+//   - glyphs whose box touches an area are taken out of their string and
+//     replaced by a TJ displacement of the same width, so the text after them
+//     stays in place; marked content enclosing them loses its /ActualText,
+//     /Alt and /E replacement text;
+//   - painted paths and shadings (sh) touching an area are dropped (a
+//     clipping path they set is kept);
+//   - the pixels of images, inline or XObject, inside an area are blanked and
+//     the image re-encoded, or the image dropped when no pixel is left. An
+//     image whose filters cannot be decoded (DCT, JPX, JBIG2, CCITT fax) is
+//     painted clipped to the outside of the areas instead;
+//   - Form XObjects touching an area are drawn inline, with their resources
+//     renamed into the template's, and redacted the same way.
+//
+// XObjects no longer painted afterwards are left out of the resources, so
+// their data is not copied. Text in a Type 0 font whose CMap is not
+// Identity-H or Identity-V is refused: without reading the CMap neither the
+// glyph boundaries nor the widths are known.
+
+// maxRedactDepth limits the nesting of Form XObjects drawn inline. Deeper
+// forms touching an area are dropped.
+const maxRedactDepth = 16
+
+// redactor redacts the content of one template.
+type redactor struct {
+	pw      *PdfWriter
+	tpl     *pdfTemplate
+	r       *src.Reader
+	c       *sourceCrypt
+	regions [][4]float64
+	fonts   map[string]*fontMetrics
+	forms   int // Form XObjects drawn inline, numbering their resource prefixes
+	images  int // redacted images, numbering their resource names
+	removed int // text operations that lost glyphs
+}
+
+// strokes are the path painting operators that stroke the path.
+var strokes = map[string]bool{"S": true, "s": true, "B": true, "B*": true, "b": true, "b*": true}
+
+// redactState is the part of the graphics state redaction needs.
+type redactState struct {
+	ctm                   Matrix
+	clip                  [4]float64 // bounding box of the clipping path
+	lineWidth             float64
+	font                  *fontMetrics
+	size                  float64
+	charSpace, wordSpace  float64
+	hscale, leading, rise float64
+}
+
+// fontMetrics holds the glyph widths of a font, in glyph space.
+type fontMetrics struct {
+	twoByte bool // Type 0 font; codes are two bytes, taken as CIDs
+	first   int64
+	widths  []float64
+	cids    map[int]float64
+	missing float64
+	scale   float64 // glyph space to text space
+	err     error   // set for text that cannot be redacted
+}
+
+// width returns the horizontal displacement of code in text space units for
+// a font size of 1.
+func (f *fontMetrics) width(code int) float64 {
+	if f == nil {
+		return 0.5
+	}
+	w := f.missing
+	if f.twoByte {
+		if cw, ok := f.cids[code]; ok {
+			w = cw
+		}
+	} else if i := int64(code) - f.first; i >= 0 && i < int64(len(f.widths)) {
+		w = f.widths[i]
+	}
+	return w * f.scale
+}
+
+// redact removes the content of tpl drawn in the areas of cfg, then paints
+// the areas when a fill color is set.
+func (pw *PdfWriter) redact(tpl *pdfTemplate, cfg importConfig) error {
+	rd := &redactor{
+		pw:      pw,
+		tpl:     tpl,
+		r:       pw.sources[tpl.source],
+		c:       pw.crypt(tpl.source),
+		regions: cfg.redactions,
+		fonts:   make(map[string]*fontMetrics),
+	}
+	ops, err := ParseContent(tpl.content)
+	if err != nil {
+		return err
+	}
+	clip := normalizeRect([4]float64{tpl.box["llx"], tpl.box["lly"], tpl.box["urx"], tpl.box["ury"]})
+	out, err := rd.redact(ops, redactState{ctm: IdentityMatrix, clip: clip, lineWidth: 1, hscale: 1}, 0)
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	b.WriteString("q\n")
+	b.Write(SerializeContent(out))
+	b.WriteString("Q\n")
+	if c := cfg.redactFill; c != nil {
+		fmt.Fprintf(&b, "q %s %s %s rg\n", formatNumber(c[0]), formatNumber(c[1]), formatNumber(c[2]))
+		for _, r := range cfg.redactions {
+			fmt.Fprintf(&b, "%s %s %s %s re\n", formatNumber(r[0]), formatNumber(r[1]), formatNumber(r[2]-r[0]), formatNumber(r[3]-r[1]))
+		}
+		b.WriteString("f\nQ\n")
+	}
+	tpl.content = b.Bytes()
+	tpl.redactions = cfg.redactions
+
+	// Leave out the XObjects nothing paints any more.
+	painted, ok := rd.painted(out)
+	if !ok {
+		return nil
+	}
+	extra := tpl.extraRes[:0]
+	for _, e := range tpl.extraRes {
+		if e.category != "XObject" || painted[e.name] {
+			extra = append(extra, e)
+		}
+	}
+	tpl.extraRes = extra
+	if xobjs, ok := tpl.resources.Dict("XObject"); ok {
+		for _, name := range xobjs.Keys() {
+			if !painted[name] {
+				if tpl.dropped == nil {
+					tpl.dropped = make(map[string]bool)
+				}
+				tpl.dropped[name] = true
+			}
+		}
+	}
+	return nil
+}
+
+// painted returns the names of the XObjects ops paint, including those
+// painted by forms without /Resources of their own, which use the page's.
+// ok is false when the content of such a form cannot be read.
+func (rd *redactor) painted(ops []ContentOp) (names map[string]bool, ok bool) {
+	names = make(map[string]bool)
+	for len(ops) > 0 {
+		var next []ContentOp
+		for _, op := range ops {
+			if op.Operator != "Do" || len(op.Operands) != 1 || names[op.Operands[0].Name] {
+				continue
+			}
+			names[op.Operands[0].Name] = true
+			obj, ref := rd.resource("XObject", op.Operands[0].Name)
+			s, ok := obj.(*src.Stream)
+			if !ok {
+				continue
+			}
+			if sub, _ := s.Dict.Name("Subtype"); sub != "Form" || s.Dict.Has("Resources") {
+				continue
+			}
+			content, err := rd.c.content(s, ref)
+			if err != nil {
+				return nil, false
+			}
+			inner, err := ParseContent(content)
+			if err != nil {
+				return nil, false
+			}
+			next = append(next, inner...)
+		}
+		ops = next
+	}
+	return names, true
+}
+
+// hits reports whether rect, in default user space, touches an area.
+func (rd *redactor) hits(rect [4]float64) bool {
+	for _, region := range rd.regions {
+		if overlaps(rect, region) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact returns ops without what they draw in the areas. st is the state
+// at the start of ops; depth counts the Form XObjects drawn inline around
+// them.
+func (rd *redactor) redact(ops []ContentOp, st redactState, depth int) ([]ContentOp, error) {
+	var (
+		out      []ContentOp
+		stack    []redactState
+		sections []mcSection
+		path     []ContentOp
+		pathBox  = emptyBox()
+		clip     bool
+		tm, tlm  = IdentityMatrix, IdentityMatrix
+		moveText = func(tx, ty float64) {
+			tlm = Matrix{1, 0, 0, 1, tx, ty}.Multiply(tlm)
+			tm = tlm
+		}
+		textRemoved = func() {
+			rd.removed++
+			for i := range sections {
+				sections[i].hit = true
+			}
+		}
+	)
+	for _, op := range ops {
+		n := operandNumbers(op.Operands)
+		switch op.Operator {
+		case "q":
+			stack = append(stack, st)
+		case "Q":
+			if len(stack) > 0 {
+				st = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+		case "cm":
+			if len(n) == 6 {
+				st.ctm = Matrix{n[0], n[1], n[2], n[3], n[4], n[5]}.Multiply(st.ctm)
+			}
+		case "w":
+			if len(n) == 1 {
+				st.lineWidth = n[0]
+			}
+
+		case "m", "l", "c", "v", "y", "h", "re":
+			pts := n
+			if op.Operator == "re" && len(n) == 4 {
+				pts = []float64{n[0], n[1], n[0] + n[2], n[1] + n[3]}
+			}
+			for i := 0; i+1 < len(pts); i += 2 {
+				x, y := st.ctm.Apply(pts[i], pts[i+1])
+				pathBox = extendBox(pathBox, x, y)
+			}
+			path = append(path, op)
+			continue
+		case "W", "W*":
+			clip = true
+			path = append(path, op)
+			continue
+		case "S", "s", "f", "F", "f*", "B", "B*", "b", "b*", "n":
+			if clip {
+				st.clip = intersectBox(st.clip, pathBox)
+			}
+			paint := pathBox
+			if strokes[op.Operator] {
+				paint = padBox(paint, st.ctm, st.lineWidth/2)
+			}
+			switch {
+			case op.Operator == "n" || !rd.hits(paint):
+				out = append(out, path...)
+				out = append(out, op)
+			case clip:
+				out = append(out, path...)
+				out = append(out, ContentOp{Operator: "n"})
+			}
+			path, pathBox, clip = nil, emptyBox(), false
+			continue
+		case "sh":
+			if rd.shadingHits(op, st) {
+				continue
+			}
+
+		case "BMC":
+			sections = append(sections, mcSection{})
+		case "BDC":
+			sections = append(sections, mcSection{props: []int{len(out)}})
+		case "DP":
+			if k := len(sections); k > 0 {
+				sections[k-1].props = append(sections[k-1].props, len(out))
+			}
+		case "EMC":
+			if k := len(sections); k > 0 {
+				if sections[k-1].hit {
+					rd.blankAltText(out, sections[k-1].props)
+				}
+				sections = sections[:k-1]
+			}
+
+		case "BT":
+			tm, tlm = IdentityMatrix, IdentityMatrix
+		case "Tf":
+			if len(op.Operands) == 2 && op.Operands[0].Kind == OperandName {
+				st.font = rd.font(op.Operands[0].Name)
+				st.size = op.Operands[1].Number
+			}
+		case "Tc", "Tw", "Tz", "TL", "Ts":
+			if len(n) != 1 {
+				break
+			}
+			switch op.Operator {
+			case "Tc":
+				st.charSpace = n[0]
+			case "Tw":
+				st.wordSpace = n[0]
+			case "Tz":
+				st.hscale = n[0] / 100
+			case "TL":
+				st.leading = n[0]
+			case "Ts":
+				st.rise = n[0]
+			}
+		case "Td", "TD":
+			if len(n) == 2 {
+				if op.Operator == "TD" {
+					st.leading = -n[1]
+				}
+				moveText(n[0], n[1])
+			}
+		case "Tm":
+			if len(n) == 6 {
+				tlm = Matrix{n[0], n[1], n[2], n[3], n[4], n[5]}
+				tm = tlm
+			}
+		case "T*":
+			moveText(0, -st.leading)
+		case "Tj", "TJ", "'", "\"":
+			var pre []ContentOp
+			switch op.Operator {
+			case "'":
+				pre = []ContentOp{{Operator: "T*"}}
+			case "\"":
+				if len(op.Operands) == 3 {
+					st.wordSpace, st.charSpace = op.Operands[0].Number, op.Operands[1].Number
+					pre = []ContentOp{
+						{Operator: "Tw", Operands: op.Operands[:1]},
+						{Operator: "Tc", Operands: op.Operands[1:2]},
+						{Operator: "T*"},
+					}
+				}
+			}
+			if pre != nil {
+				moveText(0, -st.leading)
+			}
+			if len(op.Operands) == 0 {
+				break
+			}
+			shown, changed, err := rd.showText(op.Operands[len(op.Operands)-1], st, &tm)
+			if err != nil {
+				return nil, err
+			}
+			if !changed {
+				break
+			}
+			textRemoved()
+			out = append(out, pre...)
+			out = append(out, ContentOp{Operator: "TJ", Operands: []Operand{shown}})
+			continue
+
+		case "EI":
+			out = append(out, rd.paintInlineImage(op, st)...)
+			continue
+		case "Do":
+			removed := rd.removed
+			repl, err := rd.paintXObject(op, st, depth)
+			if err != nil {
+				return nil, err
+			}
+			if rd.removed != removed {
+				textRemoved()
+			}
+			out = append(out, repl...)
+			continue
+		}
+		out = append(out, op)
+	}
+	for _, sec := range sections {
+		if sec.hit {
+			rd.blankAltText(out, sec.props)
+		}
+	}
+	// An unpainted path at the end draws nothing.
+	return append(out, path...), nil
+}
+
+// mcSection is an open marked-content section: the positions in the output
+// of its BDC and DP operations, and whether glyphs inside it were removed.
+type mcSection struct {
+	props []int
+	hit   bool
+}
+
+// altTextKeys are the marked-content properties that stand in for the
+// enclosed text.
+var altTextKeys = []string{"ActualText", "Alt", "E"}
+
+// blankAltText empties the replacement text in the property lists of the
+// operations out[idx…]. A property list named in the resources is written
+// inline instead.
+func (rd *redactor) blankAltText(out []ContentOp, idx []int) {
+	for _, i := range idx {
+		op := out[i]
+		if len(op.Operands) != 2 {
+			continue
+		}
+		var props map[string]Operand
+		switch p := op.Operands[1]; p.Kind {
+		case OperandDict:
+			props = maps.Clone(p.Dict)
+		case OperandName:
+			obj, owner := rd.resource("Properties", p.Name)
+			d, ok := obj.(*src.Dict)
+			if !ok || op.Operands[0].Name == "OC" {
+				continue // optional content names its group
+			}
+			props = make(map[string]Operand)
+			for k, v := range d.Iter() {
+				if o, ok := rd.operand(v, owner, 0); ok {
+					props[k] = o
+				}
+			}
+		default:
+			continue
+		}
+		blanked := false
+		for _, k := range altTextKeys {
+			if _, ok := props[k]; ok {
+				props[k] = Operand{Kind: OperandString}
+				blanked = true
+			}
+		}
+		if blanked {
+			out[i].Operands = []Operand{op.Operands[0], {Kind: OperandDict, Dict: props}}
+		}
+	}
+}
+
+// operand converts obj, held by owner, into a content stream operand.
+// Streams have no direct form; ok is false for them.
+func (rd *redactor) operand(obj src.Object, owner src.Reference, depth int) (Operand, bool) {
+	if depth > 16 {
+		return Operand{}, false
+	}
+	obj, owner = resolveOwned(rd.r, obj, owner)
+	switch v := obj.(type) {
+	case src.Name:
+		return Operand{Kind: OperandName, Name: string(v)}, true
+	case src.Integer:
+		return Operand{Kind: OperandNumber, Number: float64(v)}, true
+	case src.Real:
+		return Operand{Kind: OperandNumber, Number: float64(v)}, true
+	case src.Bool:
+		return Operand{Kind: OperandBool, Bool: bool(v)}, true
+	case src.String:
+		return Operand{Kind: OperandString, Bytes: rd.c.plain(v, owner)}, true
+	case src.Array:
+		o := Operand{Kind: OperandArray}
+		for _, e := range v {
+			eo, ok := rd.operand(e, owner, depth+1)
+			if !ok {
+				return Operand{}, false
+			}
+			o.Array = append(o.Array, eo)
+		}
+		return o, true
+	case *src.Dict:
+		o := Operand{Kind: OperandDict, Dict: make(map[string]Operand)}
+		for k, e := range v.Iter() {
+			if eo, ok := rd.operand(e, owner, depth+1); ok {
+				o.Dict[k] = eo
+			}
+		}
+		return o, true
+	case src.Null, nil:
+		return Operand{Kind: OperandNull}, true
+	}
+	return Operand{}, false
+}
+
+// shadingHits reports whether the sh operation op paints in an area. The
+// shading fills the clipping path, limited to its /BBox; without one it
+// covers all of st.clip, the bounding box of the clipping path, which is the
+// page when nothing clips.
+func (rd *redactor) shadingHits(op ContentOp, st redactState) bool {
+	box := st.clip
+	if len(op.Operands) == 1 && op.Operands[0].Kind == OperandName {
+		var d *src.Dict
+		switch sh, _ := rd.resource("Shading", op.Operands[0].Name); sh := sh.(type) {
+		case *src.Dict:
+			d = sh
+		case *src.Stream:
+			d = sh.Dict
+		}
+		if b, ok := dictNumbers(rd.r, d, "BBox"); ok && len(b) == 4 {
+			box = intersectBox(box, st.ctm.transformRect(normalizeRect([4]float64(b))))
+		}
+	}
+	return box[0] < box[2] && box[1] < box[3] && rd.hits(box)
+}
+
+// showText advances the text matrix tm over the string or TJ array shown
+// and returns it as a TJ array with the glyphs that touch an area replaced
+// by displacements. changed is false when no glyph does.
+func (rd *redactor) showText(shown Operand, st redactState, tm *Matrix) (Operand, bool, error) {
+	if st.font != nil && st.font.err != nil {
+		return Operand{}, false, st.font.err
+	}
+	elems := []Operand{shown}
+	if shown.Kind == OperandArray {
+		elems = shown.Array
+	}
+	var arr []Operand
+	changed := false
+	var kept []byte
+	flush := func() {
+		if kept != nil {
+			arr = append(arr, Operand{Kind: OperandString, Bytes: kept})
+			kept = nil
+		}
+	}
+	gap := func(n float64) {
+		flush()
+		if k := len(arr); k > 0 && arr[k-1].Kind == OperandNumber {
+			arr[k-1].Number += n
+			return
+		}
+		arr = append(arr, Operand{Kind: OperandNumber, Number: n})
+	}
+	scale := st.size * st.hscale
+	for _, e := range elems {
+		switch e.Kind {
+		case OperandNumber:
+			*tm = Matrix{1, 0, 0, 1, -e.Number / 1000 * scale, 0}.Multiply(*tm)
+			gap(e.Number)
+		case OperandString:
+			step := 1
+			if st.font != nil && st.font.twoByte {
+				step = 2
+			}
+			trm := Matrix{scale, 0, 0, st.size, 0, st.rise}.Multiply(*tm).Multiply(st.ctm)
+			for i := 0; i+step <= len(e.Bytes); i += step {
+				code := int(e.Bytes[i])
+				if step == 2 {
+					code = code<<8 | int(e.Bytes[i+1])
+				}
+				w0 := st.font.width(code)
+				adv := w0*st.size + st.charSpace
+				if step == 1 && code == ' ' {
+					adv += st.wordSpace
+				}
+				adv *= st.hscale
+				box := trm.transformRect([4]float64{0, -0.25, math.Max(w0, 0), 1})
+				if rd.hits(box) {
+					changed = true
+					if scale != 0 {
+						gap(-adv / scale * 1000)
+					}
+				} else {
+					kept = append(kept, e.Bytes[i:i+step]...)
+				}
+				*tm = Matrix{1, 0, 0, 1, adv, 0}.Multiply(*tm)
+				trm = Matrix{scale, 0, 0, st.size, 0, st.rise}.Multiply(*tm).Multiply(st.ctm)
+			}
+		}
+	}
+	flush()
+	return Operand{Kind: OperandArray, Array: arr}, changed, nil
+}
+
+// paintXObject returns what replaces the Do operation op: op itself, a
+// redacted copy of an image touching an area, or the content of a form
+// touching one, drawn inline and redacted.
+func (rd *redactor) paintXObject(op ContentOp, st redactState, depth int) ([]ContentOp, error) {
+	keep := []ContentOp{op}
+	if len(op.Operands) != 1 || op.Operands[0].Kind != OperandName {
+		return keep, nil
+	}
+	obj, ref := rd.resource("XObject", op.Operands[0].Name)
+	s, ok := obj.(*src.Stream)
+	if !ok {
+		return keep, nil
+	}
+	switch sub, _ := s.Dict.Name("Subtype"); sub {
+	case "Image":
+		box := st.ctm.transformRect([4]float64{0, 0, 1, 1})
+		if !rd.hits(box) {
+			return keep, nil
+		}
+		g, covered := rd.redactImage(s, ref, st.ctm)
+		if covered || g == nil {
+			// Image data gofpdi cannot decode goes as a whole.
+			return nil, nil
+		}
+		name := fmt.Sprintf("GOFPDIRI%d", rd.images)
+		rd.images++
+		pw := rd.pw
+		rd.tpl.extraRes = append(rd.tpl.extraRes, resEntry{category: "XObject", name: name, token: func() string { return pw.genRef(g) }})
+		return []ContentOp{{Operator: "Do", Operands: []Operand{{Kind: OperandName, Name: name}}}}, nil
+	case "Form":
+		bbox, ok := dictNumbers(rd.r, s.Dict, "BBox")
+		if !ok || len(bbox) != 4 {
+			return keep, nil
+		}
+		box := normalizeRect([4]float64{bbox[0], bbox[1], bbox[2], bbox[3]})
+		fm := IdentityMatrix
+		if m, ok := dictNumbers(rd.r, s.Dict, "Matrix"); ok && len(m) == 6 {
+			fm = Matrix{m[0], m[1], m[2], m[3], m[4], m[5]}
+		}
+		m := fm.Multiply(st.ctm)
+		if !rd.hits(m.transformRect(box)) {
+			return keep, nil
+		}
+		if depth >= maxRedactDepth {
+			return nil, nil
+		}
+		content, err := rd.c.content(s, ref)
+		if err != nil {
+			return nil, fmt.Errorf("gofpdi: read form content: %w", err)
+		}
+		obj, resOwner := resolveOwned(rd.r, entry(s.Dict, "Resources"), ref)
+		if res, ok := obj.(*src.Dict); ok {
+			// The form's resources join the template's under a prefix of
+			// their own; a form without resources uses the page's.
+			prefix := fmt.Sprintf("GOFPDIR%d_", rd.forms)
+			rd.forms++
+			names := make(map[string]map[string]bool)
+			for category, v := range res.Iter() {
+				obj, owner := resolveOwned(rd.r, v, resOwner)
+				sub, ok := obj.(*src.Dict)
+				if !ok || !resourceCategories[category] {
+					continue
+				}
+				names[category] = make(map[string]bool)
+				for name, obj := range sub.Iter() {
+					names[category][name] = true
+					rd.tpl.extraRes = append(rd.tpl.extraRes, resEntry{category: category, name: prefix + name, obj: obj, owner: owner})
+				}
+			}
+			if content, err = prefixContent(content, names, prefix); err != nil {
+				return nil, err
+			}
+		}
+		ops, err := ParseContent(content)
+		if err != nil {
+			return nil, err
+		}
+		st.ctm = m
+		st.clip = intersectBox(st.clip, m.transformRect(box))
+		inner, err := rd.redact(ops, st, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		out := []ContentOp{
+			{Operator: "q"},
+			{Operator: "cm", Operands: numberOperands(fm[:]...)},
+			{Operator: "re", Operands: numberOperands(box[0], box[1], box[2]-box[0], box[3]-box[1])},
+			{Operator: "W"},
+			{Operator: "n"},
+		}
+		out = append(out, inner...)
+		return append(out, ContentOp{Operator: "Q"}), nil
+	}
+	return keep, nil
+}
+
+// pixelLayout describes the decoded samples of an image: rows of width
+// pixels, each of comps components of bpc bits, every row starting on a
+// byte boundary.
+type pixelLayout struct {
+	width, height, comps, bpc int
+}
+
+// size returns the number of bytes of the samples.
+func (l pixelLayout) size() int {
+	return (l.width*l.comps*l.bpc + 7) / 8 * l.height
+}
+
+// maxRedactPixels limits the images whose pixels are redacted.
+const maxRedactPixels = 1 << 26
+
+// imageLayout returns the sample layout of an image from its dictionary
+// entries. ok is false when it cannot be worked out or the image is too big.
+func (rd *redactor) imageLayout(width, height, bpc src.Object, mask bool, cs src.Object) (pixelLayout, bool) {
+	w, _ := resolveIn(rd.r, width).(src.Integer)
+	h, _ := resolveIn(rd.r, height).(src.Integer)
+	l := pixelLayout{width: int(w), height: int(h), comps: 1, bpc: 1}
+	if l.width <= 0 || l.height <= 0 || l.width*l.height > maxRedactPixels {
+		return pixelLayout{}, false
+	}
+	if mask {
+		return l, true
+	}
+	b, _ := resolveIn(rd.r, bpc).(src.Integer)
+	comps, ok := rd.components(cs, 0)
+	switch b {
+	case 1, 2, 4, 8, 16:
+		l.bpc, l.comps = int(b), comps
+		return l, ok
+	}
+	return pixelLayout{}, false
+}
+
+// components returns the number of color components of the color space cs.
+// Names other than the device and CIE spaces are looked up in the resources.
+func (rd *redactor) components(cs src.Object, depth int) (int, bool) {
+	if depth > 4 {
+		return 0, false
+	}
+	switch v := resolveIn(rd.r, cs).(type) {
+	case src.Name:
+		switch v {
+		case "DeviceGray", "G", "CalGray", "Indexed", "I":
+			return 1, true
+		case "DeviceRGB", "RGB", "CalRGB", "Lab":
+			return 3, true
+		case "DeviceCMYK", "CMYK":
+			return 4, true
+		}
+		cs, _ := rd.resource("ColorSpace", string(v))
+		return rd.components(cs, depth+1)
+	case src.Array:
+		if len(v) == 0 {
+			break
+		}
+		family, _ := resolveIn(rd.r, v[0]).(src.Name)
+		switch family {
+		case "DeviceGray", "G", "CalGray", "Indexed", "I", "Separation":
+			return 1, true
+		case "DeviceRGB", "RGB", "CalRGB", "Lab":
+			return 3, true
+		case "DeviceCMYK", "CMYK":
+			return 4, true
+		case "DeviceN":
+			if names, ok := resolveIn(rd.r, v[1]).(src.Array); len(v) > 1 && ok {
+				return len(names), true
+			}
+		case "ICCBased":
+			if s, ok := resolveIn(rd.r, v[1]).(*src.Stream); len(v) > 1 && ok {
+				if n, ok := resolveIn(rd.r, entry(s.Dict, "N")).(src.Integer); ok && n > 0 {
+					return int(n), true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+// blankPixels sets the samples of the pixels of data that touch an area to
+// all ones (fill) or all zeros. The image is drawn into the unit square by
+// ctm, its first row at the top. covered reports whether every pixel was
+// blanked.
+func (rd *redactor) blankPixels(data []byte, l pixelLayout, ctm Matrix, fill bool) (covered bool) {
+	covered = true
+	stride := (l.width*l.comps*l.bpc + 7) / 8
+	bits := l.comps * l.bpc
+	for y := range l.height {
+		top, bottom := 1-float64(y)/float64(l.height), 1-float64(y+1)/float64(l.height)
+		if !rd.hits(ctm.transformRect([4]float64{0, bottom, 1, top})) {
+			covered = false
+			continue
+		}
+		for x := range l.width {
+			left, right := float64(x)/float64(l.width), float64(x+1)/float64(l.width)
+			if !rd.hits(ctm.transformRect([4]float64{left, bottom, right, top})) {
+				covered = false
+				continue
+			}
+			for pos := y*stride*8 + x*bits; pos < y*stride*8+(x+1)*bits; pos++ {
+				if fill {
+					data[pos/8] |= 0x80 >> (pos % 8)
+				} else {
+					data[pos/8] &^= 0x80 >> (pos % 8)
+				}
+			}
+		}
+	}
+	return covered
+}
+
+// maskFill reports whether the samples of a stencil mask with the /Decode
+// entry decode are blanked to ones: sample 1 masks, unless decode is [1 0].
+func (rd *redactor) maskFill(decode src.Object) bool {
+	d, ok := numbersIn(rd.r, decode)
+	return !ok || len(d) != 2 || d[0] == 0
+}
+
+// redactImage returns a copy of the image XObject s, the object ref, drawn
+// into the unit square by ctm, with the pixels touching an area blanked, its masks
+// likewise. g is nil when the image data cannot be decoded; covered reports
+// that nothing of the image is left.
+func (rd *redactor) redactImage(s *src.Stream, ref src.Reference, ctm Matrix) (g *genObject, covered bool) {
+	mask, _ := resolveIn(rd.r, entry(s.Dict, "ImageMask")).(src.Bool)
+	l, ok := rd.imageLayout(entry(s.Dict, "Width"), entry(s.Dict, "Height"), entry(s.Dict, "BitsPerComponent"), bool(mask), entry(s.Dict, "ColorSpace"))
+	if !ok {
+		return nil, false
+	}
+	data, err := rd.c.content(s, ref)
+	if err != nil || len(data) < l.size() {
+		return nil, false
+	}
+	data = bytes.Clone(data[:l.size()])
+	if rd.blankPixels(data, l, ctm, bool(mask) && rd.maskFill(entry(s.Dict, "Decode"))) {
+		return nil, true
+	}
+	g = &genObject{data: deflate(data), entries: s.Dict, source: rd.tpl.source, owner: ref}
+	// A soft mask blanked to zero hides its pixels, a stencil mask blanked to
+	// ones likewise; either one fully blanked hides the whole image.
+	obj, smRef := resolveOwned(rd.r, entry(s.Dict, "SMask"), ref)
+	if sm, ok := obj.(*src.Stream); ok {
+		if g.smask, covered = rd.redactImage(sm, smRef, ctm); g.smask == nil {
+			return nil, covered
+		}
+	}
+	obj, mRef := resolveOwned(rd.r, entry(s.Dict, "Mask"), ref)
+	if m, ok := obj.(*src.Stream); ok {
+		if g.mask, covered = rd.redactImage(m, mRef, ctm); g.mask == nil {
+			return nil, covered
+		}
+	}
+	return g, false
+}
+
+// paintInlineImage returns what replaces the inline image op: op itself, a
+// copy with the pixels touching an area blanked, or nothing when none is
+// left or the image cannot be decoded.
+func (rd *redactor) paintInlineImage(op ContentOp, st redactState) []ContentOp {
+	keep := []ContentOp{op}
+	box := st.ctm.transformRect([4]float64{0, 0, 1, 1})
+	if !rd.hits(box) {
+		return keep
+	}
+	var dict map[string]Operand
+	if len(op.Operands) > 0 {
+		dict = op.Operands[0].Dict
+	}
+	lookup := func(keys ...string) (Operand, bool) {
+		for _, k := range keys {
+			if o, ok := dict[k]; ok {
+				return o, true
+			}
+		}
+		return Operand{}, false
+	}
+	get := func(keys ...string) src.Object {
+		if o, ok := lookup(keys...); ok {
+			return operandObject(o)
+		}
+		return nil
+	}
+	mask, _ := get("IM", "ImageMask").(src.Bool)
+	l, ok := rd.imageLayout(get("W", "Width"), get("H", "Height"), get("BPC", "BitsPerComponent"), bool(mask), get("CS", "ColorSpace"))
+	if !ok {
+		return nil
+	}
+	data := op.Image
+	if filter, ok := lookup("F", "Filter"); ok {
+		var entries bytes.Buffer
+		entries.WriteString("/Filter ")
+		writeOperand(&entries, filter)
+		if parms, ok := lookup("DP", "DecodeParms"); ok {
+			entries.WriteString(" /DecodeParms ")
+			writeOperand(&entries, parms)
+		}
+		var err error
+		if data, err = decodeData(op.Image, entries.Bytes()); err != nil {
+			return nil
+		}
+	}
+	if len(data) < l.size() {
+		return nil
+	}
+	data = bytes.Clone(data[:l.size()])
+	if rd.blankPixels(data, l, st.ctm, bool(mask) && rd.maskFill(get("D", "Decode"))) {
+		return nil
+	}
+	dict = maps.Clone(dict)
+	for _, k := range []string{"F", "Filter", "DP", "DecodeParms"} {
+		delete(dict, k)
+	}
+	dict["F"] = Operand{Kind: OperandArray, Array: []Operand{{Kind: OperandName, Name: "AHx"}, {Kind: OperandName, Name: "Fl"}}}
+	op.Operands = []Operand{{Kind: OperandDict, Dict: dict}}
+	op.Image = []byte(hex.EncodeToString(deflate(data)) + ">")
+	return []ContentOp{op}
+}
+
+// operandObject converts the inline image operand o into an object;
+// dictionaries, which the image entries read through it never are, become
+// null.
+func operandObject(o Operand) src.Object {
+	switch o.Kind {
+	case OperandName:
+		return src.Name(o.Name)
+	case OperandNumber:
+		if o.Number == math.Trunc(o.Number) {
+			return src.Integer(o.Number)
+		}
+		return src.Real(o.Number)
+	case OperandString:
+		return src.String(o.Bytes)
+	case OperandBool:
+		return src.Bool(o.Bool)
+	case OperandArray:
+		arr := make(src.Array, len(o.Array))
+		for i, e := range o.Array {
+			arr[i] = operandObject(e)
+		}
+		return arr
+	}
+	return src.Null{}
+}
+
+// resource looks up name in the template's resources of category, its extra
+// resources first, and returns it with the object holding it.
+func (rd *redactor) resource(category, name string) (src.Object, src.Reference) {
+	for _, e := range rd.tpl.extraRes {
+		if e.category == category && e.name == name {
+			return resolveOwned(rd.r, e.obj, e.owner)
+		}
+	}
+	sub, owner := resolveOwned(rd.r, entry(rd.tpl.resources, category), rd.tpl.resOwner)
+	if sub, ok := sub.(*src.Dict); ok {
+		return resolveOwned(rd.r, entry(sub, name), owner)
+	}
+	return src.Null{}, owner
+}
+
+// font returns the metrics of the font resource name.
+func (rd *redactor) font(name string) *fontMetrics {
+	if f, ok := rd.fonts[name]; ok {
+		return f
+	}
+	f := &fontMetrics{missing: 500, scale: 0.001}
+	rd.fonts[name] = f
+	obj, _ := rd.resource("Font", name)
+	d, ok := obj.(*src.Dict)
+	if !ok {
+		return f
+	}
+	switch sub, _ := d.Name("Subtype"); sub {
+	case "Type0":
+		f.twoByte, f.missing = true, 1000
+		switch enc := resolveIn(rd.r, entry(d, "Encoding")).(type) {
+		case src.Name:
+			if enc != "Identity-H" && enc != "Identity-V" {
+				f.err = fmt.Errorf("gofpdi: cannot redact text in font %s: CMap %s is not Identity", name, enc)
+			}
+		default:
+			f.err = fmt.Errorf("gofpdi: cannot redact text in font %s: its CMap is embedded", name)
+		}
+		kids, _ := resolveIn(rd.r, entry(d, "DescendantFonts")).(src.Array)
+		if len(kids) == 0 {
+			break
+		}
+		cid, ok := resolveIn(rd.r, kids[0]).(*src.Dict)
+		if !ok {
+			break
+		}
+		if dw, ok := number(resolveIn(rd.r, entry(cid, "DW"))); ok {
+			f.missing = dw
+		}
+		f.cids = cidWidths(rd.r, entry(cid, "W"))
+	default:
+		f.widths, _ = dictNumbers(rd.r, d, "Widths")
+		f.first, _ = d.Int("FirstChar")
+		if fd, ok := resolveIn(rd.r, entry(d, "FontDescriptor")).(*src.Dict); ok {
+			if mw, ok := number(resolveIn(rd.r, entry(fd, "MissingWidth"))); ok {
+				f.missing = mw
+			}
+		}
+		if fm, ok := dictNumbers(rd.r, d, "FontMatrix"); ok && len(fm) == 6 && sub == "Type3" {
+			f.scale = fm[0]
+		}
+	}
+	return f
+}
+
+// cidWidths reads the /W array of a CIDFont: "c [w1 w2 …]" gives the widths
+// of consecutive CIDs from c, "c1 c2 w" one width for the range c1–c2.
+func cidWidths(r *src.Reader, obj src.Object) map[int]float64 {
+	arr, _ := resolveIn(r, obj).(src.Array)
+	widths := make(map[int]float64)
+	for i := 0; i < len(arr); {
+		c, ok := number(resolveIn(r, arr[i]))
+		if !ok || i+1 >= len(arr) {
+			break
+		}
+		if ws, ok := numbersIn(r, arr[i+1]); ok {
+			for j, w := range ws {
+				widths[int(c)+j] = w
+			}
+			i += 2
+			continue
+		}
+		if i+2 >= len(arr) {
+			break
+		}
+		last, ok1 := number(resolveIn(r, arr[i+1]))
+		w, ok2 := number(resolveIn(r, arr[i+2]))
+		if !ok1 || !ok2 || last-c > 65535 {
+			break
+		}
+		for cid := int(c); cid <= int(last); cid++ {
+			widths[cid] = w
+		}
+		i += 3
+	}
+	return widths
+}
+
+// operandNumbers returns the operands as numbers, or nil when one is not.
+func operandNumbers(ops []Operand) []float64 {
+	out := make([]float64, 0, len(ops))
+	for _, o := range ops {
+		if o.Kind != OperandNumber {
+			return nil
+		}
+		out = append(out, o.Number)
+	}
+	return out
+}
+
+// numberOperands returns nums as rounded number operands.
+func numberOperands(nums ...float64) []Operand {
+	out := make([]Operand, len(nums))
+	for i, f := range nums {
+		out[i] = Operand{Kind: OperandNumber, Number: math.Round(f*1e5) / 1e5}
+	}
+	return out
+}
+
+// emptyBox is the start value for extendBox.
+func emptyBox() [4]float64 {
+	return [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+}
+
+// padBox grows box by the extent of a circle of radius r in user space drawn
+// with the CTM ctm: the reach of a pen of width 2r stroking a path whose
+// points box holds.
+func padBox(box [4]float64, ctm Matrix, r float64) [4]float64 {
+	dx := r * math.Hypot(ctm[0], ctm[2])
+	dy := r * math.Hypot(ctm[1], ctm[3])
+	return [4]float64{box[0] - dx, box[1] - dy, box[2] + dx, box[3] + dy}
+}
+
+// extendBox grows box to include the point (x, y).
+func extendBox(box [4]float64, x, y float64) [4]float64 {
+	return [4]float64{math.Min(box[0], x), math.Min(box[1], y), math.Max(box[2], x), math.Max(box[3], y)}
+}
+
+// intersectBox returns the intersection of the boxes a and b, empty (with
+// llx > urx or lly > ury) when they are disjoint.
+func intersectBox(a, b [4]float64) [4]float64 {
+	return [4]float64{math.Max(a[0], b[0]), math.Max(a[1], b[1]), math.Min(a[2], b[2]), math.Min(a[3], b[3])}
+}
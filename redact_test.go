@@ -0,0 +1,207 @@
+package gofpdi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// redactFixture is a one-page PDF with two glyphs of text in marked content
+// with replacement text, two filled rectangles, three images and an inline
+// one, two shadings, a form drawing two more rectangles, a form without
+// resources drawing a fourth image, and two annotations.
+func redactFixture() []byte {
+	content := "/Span <</ActualText (AB)>> BDC BT /F1 10 Tf 10 50 Td (AB) Tj ET EMC\n" +
+		"0 0 1 rg 100 10 20 20 re f 150 10 20 20 re f\n" +
+		"q 20 0 0 20 100 60 cm /Im1 Do Q q 20 0 0 20 150 60 cm /Im2 Do Q\n" +
+		"q 40 0 0 20 70 60 cm /Im3 Do Q q 40 0 0 20 70 30 cm BI /W 2 /H 1 /CS /G /BPC 8 ID PQ EI Q\n" +
+		"q 100 40 10 10 re W n /Sh1 sh Q q 0 0 10 10 re W n /Sh1 sh Q\n" +
+		"/Fm1 Do /Fm2 Do\n"
+	form := "100 12 5 5 re f 30 80 5 5 re f"
+	form2 := "q 5 0 0 5 0 0 cm /Im4 Do Q"
+	image := func(w int, data string) string {
+		return "<</Type /XObject /Subtype /Image /Width " + itoa(w) + " /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8 /Length " + itoa(len(data)) + ">>\nstream\n" + data + "\nendstream"
+	}
+	return buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] /Contents 4 0 R /Annots [5 0 R 6 0 R]" +
+			" /Resources <</Font <</F1 7 0 R>> /XObject <</Im1 8 0 R /Im2 9 0 R /Im3 11 0 R /Im4 13 0 R /Fm1 10 0 R /Fm2 12 0 R>>" +
+			" /Shading <</Sh1 <</ShadingType 2 /ColorSpace /DeviceGray /Coords [0 0 1 0]>>>>>>>>",
+		"<</Length " + itoa(len(content)) + ">>\nstream\n" + content + "\nendstream",
+		"<</Type /Annot /Subtype /Square /Rect [100 40 110 50]>>",
+		"<</Type /Annot /Subtype /Square /Rect [0 0 5 5]>>",
+		"<</Type /Font /Subtype /Type1 /BaseFont /Helvetica /FirstChar 65 /LastChar 66 /Widths [500 500]>>",
+		image(1, "X"),
+		image(1, "Y"),
+		"<</Type /XObject /Subtype /Form /BBox [0 0 200 100] /Length " + itoa(len(form)) + ">>\nstream\n" + form + "\nendstream",
+		image(2, "PQ"),
+		"<</Type /XObject /Subtype /Form /BBox [0 0 10 10] /Length " + itoa(len(form2)) + ">>\nstream\n" + form2 + "\nendstream",
+		image(1, "Z"),
+	}, "")
+}
+
+func TestImportRedaction(t *testing.T) {
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(bytes.NewReader(redactFixture())); err != nil {
+		t.Fatal(err)
+	}
+	// The first area covers the glyph B, the second the left rectangle, the
+	// left image, the right pixel of the wide images, the first shading, a
+	// rectangle of the form and the first annotation.
+	tpl, err := imp.ImportPage(1, "/MediaBox",
+		WithRedaction([4]float64{18, 45, 16, 65}, [4]float64{95, 5, 125, 90}),
+		WithRedactionFill(0, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, _ := imp.ImportAnnots(tpl, IdentityMatrix)
+	names, err := imp.PutFormXobjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs := imp.GetImportedObjects()
+	head, content := formContent(t, objs[names["/GOFPDITPL0"]])
+
+	for _, w := range []string{
+		"/Span <</ActualText () >> BDC\n",
+		"[(A) -500] TJ\n",
+		"150 10 20 20 re\nf\n",
+		"/Im2 Do\n",
+		"/GOFPDIRI0 Do\n",
+		"BI /BPC 8 /CS /G /F [/AHx /Fl] /H 1 /W 2 ID " + hex.EncodeToString(deflate([]byte("P\x00"))) + ">\nEI\n",
+		"/Fm2 Do\n",
+		"q\n1 0 0 1 0 0 cm\n0 0 200 100 re\nW\nn\n30 80 5 5 re\nf\nQ\n",
+		"q 0 0 0 rg\n16 45 2 20 re\n95 5 30 85 re\nf\nQ\n",
+	} {
+		if !strings.Contains(string(content), w) {
+			t.Errorf("content lacks %q:\n%s", w, content)
+		}
+	}
+	for _, w := range []string{"(AB)", "100 10 20 20 re", "/Im1 Do", "/Im3 Do", "/Fm1 Do", "100 12 5 5 re", "ID PQ"} {
+		if strings.Contains(string(content), w) {
+			t.Errorf("content still has %q:\n%s", w, content)
+		}
+	}
+	if n := strings.Count(string(content), "/Sh1 sh"); n != 1 {
+		t.Errorf("%d shadings painted, want 1:\n%s", n, content)
+	}
+	for name, want := range map[string]bool{"/Im1 ": false, "/Im3 ": false, "/Fm1 ": false, "/Im2 ": true, "/Im4 ": true, "/GOFPDIRI0 ": true} {
+		if bytes.Contains(head, []byte(name)) != want {
+			t.Errorf("XObject resources, %s: %s", name, head)
+		}
+	}
+
+	// Im2, Im4 as painted by the form without resources, and Im3 with its
+	// right pixel blanked are copied.
+	images := 0
+	for _, obj := range objs {
+		if !bytes.Contains(obj, []byte("/Subtype /Image")) {
+			continue
+		}
+		images++
+		if bytes.Contains(obj, []byte("/Width 2")) {
+			if _, data := formContent(t, obj); string(data) != "P\x00" {
+				t.Errorf("redacted image data %q", data)
+			}
+		}
+	}
+	if images != 3 {
+		t.Errorf("%d images copied, want 3", images)
+	}
+	if annots := imp.GetImportedAnnots(set); len(annots) != 1 || annots[0].Rect != [4]float64{0, 0, 5, 5} {
+		t.Errorf("annotations = %+v", annots)
+	}
+}
+
+func TestImportRedactionCMap(t *testing.T) {
+	content := "BT /F1 10 Tf 10 50 Td <0001> Tj ET"
+	pdf := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] /Contents 4 0 R /Resources <</Font <</F1 5 0 R>>>>>>",
+		"<</Length " + itoa(len(content)) + ">>\nstream\n" + content + "\nendstream",
+		"<</Type /Font /Subtype /Type0 /BaseFont /Gothic /Encoding /UniJIS-UCS2-H /DescendantFonts []>>",
+	}, "")
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(bytes.NewReader(pdf)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := imp.ImportPage(1, "/MediaBox", WithRedaction([4]float64{0, 0, 5, 5})); err == nil || !strings.Contains(err.Error(), "UniJIS-UCS2-H") {
+		t.Errorf("err = %v, want a CMap error", err)
+	}
+}
+
+func TestImportRedactionUndecodable(t *testing.T) {
+	// gofpdi cannot decode DCT data; the image touching the area goes as a
+	// whole.
+	content := "q 40 0 0 20 70 60 cm /Im1 Do Q"
+	pdf := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] /Contents 4 0 R /Resources <</XObject <</Im1 5 0 R>>>>>>",
+		"<</Length " + itoa(len(content)) + ">>\nstream\n" + content + "\nendstream",
+		"<</Type /XObject /Subtype /Image /Width 2 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /DCTDecode /Length 2>>\nstream\nPQ\nendstream",
+	}, "")
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(bytes.NewReader(pdf)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := imp.ImportPage(1, "/MediaBox", WithRedaction([4]float64{95, 5, 125, 90})); err != nil {
+		t.Fatal(err)
+	}
+	names, err := imp.PutFormXobjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs := imp.GetImportedObjects()
+	if _, got := formContent(t, objs[names["/GOFPDITPL0"]]); bytes.Contains(got, []byte("Do")) {
+		t.Errorf("content still paints the image:\n%s", got)
+	}
+	for id, obj := range objs {
+		if bytes.Contains(obj, []byte("DCTDecode")) {
+			t.Errorf("object %d copies the image data: %s", id, obj)
+		}
+	}
+}
+
+func TestImportRedactionStrokeAndShading(t *testing.T) {
+	// The first line reaches into the area only with its width, scaled by
+	// the CTM; the second stays clear of it. The shading has no /BBox and
+	// fills a clipping path away from the area.
+	content := "q 2 0 0 2 0 0 cm 3 w 5 20 m 95 20 l S 1 w 5 10 m 95 10 l S Q " +
+		"q 0 0 50 20 re W n /Sh1 sh Q"
+	pdf := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] /Contents 4 0 R /Resources <</Shading <</Sh1 <</ShadingType 2 /ColorSpace /DeviceGray /Coords [0 0 50 0] /Function <</FunctionType 2 /Domain [0 1] /N 1>>>>>>>>>>",
+		"<</Length " + itoa(len(content)) + ">>\nstream\n" + content + "\nendstream",
+	}, "")
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(bytes.NewReader(pdf)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := imp.ImportPage(1, "/MediaBox", WithRedaction([4]float64{0, 42, 200, 60})); err != nil {
+		t.Fatal(err)
+	}
+	names, err := imp.PutFormXobjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, got := formContent(t, imp.GetImportedObjects()[names["/GOFPDITPL0"]])
+	for _, gone := range []string{"5 20 m", "95 20 l"} {
+		if bytes.Contains(got, []byte(gone)) {
+			t.Errorf("content keeps %q of the wide line:\n%s", gone, got)
+		}
+	}
+	for _, kept := range []string{"5 10 m\n95 10 l\nS", "/Sh1 sh"} {
+		if !bytes.Contains(got, []byte(kept)) {
+			t.Errorf("content lost %q:\n%s", kept, got)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package gofpdi
+
+import (
+	src "github.com/speedata/pdfdisassembler"
+)
+
+// resourceCategories are the resource dictionary entries whose keys the
+// content stream refers to by name. /ProcSet is a plain array and never
+// renamed.
+var resourceCategories = map[string]bool{
+	"ExtGState":  true,
+	"ColorSpace": true,
+	"Pattern":    true,
+	"Shading":    true,
+	"XObject":    true,
+	"Font":       true,
+	"Properties": true,
+}
+
+// resourceNames returns the names tpl defines in each resource category: the
+// page resources plus the template's extra resources.
+func (pw *PdfWriter) resourceNames(tpl *pdfTemplate) map[string]map[string]bool {
+	names := make(map[string]map[string]bool)
+	add := func(category, name string) {
+		if names[category] == nil {
+			names[category] = make(map[string]bool)
+		}
+		names[category][name] = true
+	}
+	if tpl.resources != nil {
+		for category, v := range tpl.resources.Iter() {
+			if !resourceCategories[category] {
+				continue
+			}
+			if sub, ok := resolveIn(pw.sources[tpl.source], v).(*src.Dict); ok {
+				for _, name := range sub.Keys() {
+					add(category, name)
+				}
+			}
+		}
+	}
+	for _, e := range tpl.extraRes {
+		add(e.category, e.name)
+	}
+	return names
+}
+
+// prefixContent rewrites the resource names the operations of content use,
+// prepending prefix to every name defined in names (see resourceNames).
+// Names that are not resources, such as /DeviceRGB for cs, stay as they are.
+func prefixContent(content []byte, names map[string]map[string]bool, prefix string) ([]byte, error) {
+	ops, err := ParseContent(content)
+	if err != nil {
+		return nil, err
+	}
+	rename := func(category string, o *Operand) {
+		if o.Kind == OperandName && names[category][o.Name] {
+			o.Name = prefix + o.Name
+		}
+	}
+	for i := range ops {
+		op := &ops[i]
+		n := len(op.Operands)
+		if n == 0 {
+			continue
+		}
+		switch op.Operator {
+		case "Tf":
+			rename("Font", &op.Operands[0])
+		case "Do":
+			rename("XObject", &op.Operands[0])
+		case "gs":
+			rename("ExtGState", &op.Operands[0])
+		case "cs", "CS":
+			rename("ColorSpace", &op.Operands[0])
+		case "scn", "SCN":
+			rename("Pattern", &op.Operands[n-1])
+		case "sh":
+			rename("Shading", &op.Operands[0])
+		case "BDC", "DP":
+			if n > 1 {
+				rename("Properties", &op.Operands[1])
+			}
+		case "EI":
+			// An inline image names its color space under the full or the
+			// abbreviated key; an indexed space has its base second in an
+			// array.
+			d := op.Operands[0].Dict
+			for _, key := range []string{"ColorSpace", "CS"} {
+				cs, ok := d[key]
+				if !ok {
+					continue
+				}
+				if cs.Kind == OperandArray && len(cs.Array) > 1 {
+					rename("ColorSpace", &cs.Array[1])
+				} else {
+					rename("ColorSpace", &cs)
+				}
+				d[key] = cs
+			}
+		}
+	}
+	return SerializeContent(ops), nil
+}
+
+// writeResources writes the /Resources dictionary of tpl: the page resources
+// with the template's extra resources merged in. A non-empty prefix is
+// prepended to every name in the resource categories (see prefixContent).
+func (pw *PdfWriter) writeResources(tpl *pdfTemplate, prefix string) {
+	b := pw.currentObj
+	if len(tpl.extraRes) == 0 && prefix == "" && len(tpl.dropped) == 0 {
+		if tpl.resources == nil {
+			b.WriteString("<<>>")
+		} else {
+			pw.writeDict(tpl.resources)
+		}
+		return
+	}
+	written := make(map[string]bool)
+	b.WriteString("<<")
+	if tpl.resources != nil {
+		for k, v := range tpl.resources.Iter() {
+			b.WriteString("/" + escapeName(k) + " ")
+			if !hasCategory(tpl.extraRes, k) && (prefix == "" || !resourceCategories[k]) && (k != "XObject" || len(tpl.dropped) == 0) {
+				pw.writeObject(v)
+				continue
+			}
+			b.WriteString("<<")
+			owner := pw.owner
+			if sub, ok := pw.resolve(v).(*src.Dict); ok {
+				if ref, ok := v.(src.Reference); ok {
+					pw.owner = ref
+				}
+				for name, obj := range sub.Iter() {
+					if k == "XObject" && tpl.dropped[name] {
+						continue
+					}
+					b.WriteString("/" + escapeName(prefix+name) + " ")
+					pw.writeObject(obj)
+				}
+			}
+			pw.owner = owner
+			pw.writeExtraRes(tpl.extraRes, k, prefix)
+			b.WriteString(">>")
+			written[k] = true
+		}
+	}
+	for _, e := range tpl.extraRes {
+		if !written[e.category] {
+			b.WriteString("/" + e.category + " <<")
+			pw.writeExtraRes(tpl.extraRes, e.category, prefix)
+			b.WriteString(">>")
+			written[e.category] = true
+		}
+	}
+	b.WriteString(">>")
+}
+
+// writeExtraRes writes the entries of one resource category, their names
+// prefixed with prefix.
+func (pw *PdfWriter) writeExtraRes(extra []resEntry, category, prefix string) {
+	for _, e := range extra {
+		if e.category == category {
+			pw.currentObj.WriteString("/" + escapeName(prefix+e.name) + " ")
+			if e.token != nil {
+				pw.currentObj.WriteString(e.token() + " ")
+				continue
+			}
+			owner := pw.owner
+			pw.owner = e.owner
+			pw.writeObject(e.obj)
+			pw.owner = owner
+		}
+	}
+}
+
+// hasCategory reports whether extra adds entries to category.
+func hasCategory(extra []resEntry, category string) bool {
+	for _, e := range extra {
+		if e.category == category {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package gofpdi
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestImportResourcePrefix(t *testing.T) {
+	r, err := os.Open("testdata/sample.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(r); err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := imp.ImportPage(1, "/MediaBox")
+	named, _ := imp.ImportPage(1, "/MediaBox", WithResourcePrefix("Q7"))
+	auto, _ := imp.ImportPage(1, "/MediaBox", WithResourcePrefix(""))
+	if plain == named || named == auto {
+		t.Fatalf("prefixed imports share templates: %d %d %d", plain, named, auto)
+	}
+	names, err := imp.PutFormXobjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs := imp.GetImportedObjects()
+	plainDict, _ := formContent(t, objs[names["/GOFPDITPL0"]])
+	namedDict, namedContent := formContent(t, objs[names["/GOFPDITPL1"]])
+	autoDict, autoContent := formContent(t, objs[names["/GOFPDITPL2"]])
+
+	for _, want := range []string{"/ColorSpace <</CS0 ", "/Font <</TT0 "} {
+		if !bytes.Contains(plainDict, []byte(want)) {
+			t.Errorf("unprefixed template lacks %q:\n%s", want, plainDict)
+		}
+	}
+	for _, tc := range []struct {
+		prefix        string
+		dict, content []byte
+	}{
+		{"Q7", namedDict, namedContent},
+		{"GOFPDI2_", autoDict, autoContent},
+	} {
+		for _, want := range []string{"/ColorSpace <</" + tc.prefix + "CS0 ", "/Font <</" + tc.prefix + "TT0 "} {
+			if !bytes.Contains(tc.dict, []byte(want)) {
+				t.Errorf("template lacks %q:\n%s", want, tc.dict)
+			}
+		}
+		// Every name operand of cs and Tf refers to a renamed resource.
+		ops := regexp.MustCompile(`/(\S+) (?:cs|\d+ Tf)\n`).FindAllSubmatch(tc.content, -1)
+		if len(ops) == 0 {
+			t.Errorf("no cs or Tf operators in:\n%s", tc.content)
+		}
+		for _, m := range ops {
+			if !bytes.HasPrefix(m[1], []byte(tc.prefix)) {
+				t.Errorf("operand %s not renamed with %s", m[0], tc.prefix)
+			}
+		}
+	}
+	// The fonts are still copied once.
+	if !bytes.Equal(regexp.MustCompile(`TT0 \d+ 0 R`).Find(plainDict), regexp.MustCompile(`TT0 \d+ 0 R`).Find(namedDict)) {
+		t.Error("prefixed template does not share the font object")
+	}
+}
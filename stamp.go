@@ -0,0 +1,260 @@
+package gofpdi
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+
+	src "github.com/speedata/pdfdisassembler"
+)
+
+// Stamp positions a watermark or stamp drawn into a template by
+// WithTextStamp, WithImageStamp or WithTemplateStamp.
+type Stamp struct {
+	// X and Y place the stamp in the template as drawn, with the origin at
+	// the lower left corner of its extent (see GetTemplateExtent): the start
+	// of the baseline of text, the lower left corner of an image or
+	// template.
+	X, Y float64
+	// Rotation turns the stamp clockwise by degrees about (X, Y), as
+	// WithRotation turns pages; -45 runs text diagonally upwards.
+	Rotation float64
+	// Opacity between 0 and 1 makes the stamp translucent through an
+	// ExtGState. 0, the zero value, and 1 paint it opaque.
+	Opacity float64
+	// Color is the RGB fill color (each 0–1) of text; the zero value is
+	// black.
+	Color [3]float64
+	// Under draws the stamp beneath the page content rather than over it.
+	Under bool
+}
+
+// stamp is one stamp of an import, with what it draws: text in a standard
+// font, an image or a template.
+type stamp struct {
+	Stamp
+	text     string
+	font     string
+	size     float64
+	img      *genObject
+	w, h     float64 // image size
+	template int
+}
+
+// key describes s for importConfig.key. Images are compared by identity, so
+// an option reused for several imports shares its image.
+func (s stamp) key() string {
+	switch {
+	case s.img != nil:
+		return fmt.Sprintf("image(%p %g %g) %v", s.img, s.w, s.h, s.Stamp)
+	case s.font != "":
+		return fmt.Sprintf("text(%q %q %g) %v", s.text, s.font, s.size, s.Stamp)
+	}
+	return fmt.Sprintf("template(%d) %v", s.template, s.Stamp)
+}
+
+// standardFonts are the standard 14 fonts, true for the symbolic ones that
+// have their own built-in encoding.
+var standardFonts = map[string]bool{
+	"Times-Roman": false, "Times-Bold": false, "Times-Italic": false, "Times-BoldItalic": false,
+	"Helvetica": false, "Helvetica-Bold": false, "Helvetica-Oblique": false, "Helvetica-BoldOblique": false,
+	"Courier": false, "Courier-Bold": false, "Courier-Oblique": false, "Courier-BoldOblique": false,
+	"Symbol": true, "ZapfDingbats": true,
+}
+
+// genObject is a stream gofpdi creates rather than copies from a source. It
+// is numbered and written the first time a template refers to it.
+type genObject struct {
+	dict  string // entries besides /Filter, /Length and /SMask
+	data  []byte // Flate-compressed
+	smask *genObject
+	mask  *genObject // a stencil /Mask
+	// entries, from the given source, are copied into the dictionary as
+	// well, all but those about the stream data (redacted images); owner is
+	// the object they come from.
+	entries *src.Dict
+	source  int
+	owner   src.Reference
+}
+
+// genRef returns a reference to g, numbering it and queueing it for drain on
+// first use.
+func (pw *PdfWriter) genRef(g *genObject) string {
+	id, ok := pw.genIDs[g]
+	if !ok {
+		id = pw.reserveObjectID()
+		pw.genIDs[g] = id
+		pw.genQueue = append(pw.genQueue, g)
+	}
+	return fmt.Sprintf("%d 0 R", id)
+}
+
+// writeGenObject serializes g into currentObj.
+func (pw *PdfWriter) writeGenObject(g *genObject) {
+	b := pw.currentObj
+	b.WriteString("<<" + g.dict)
+	if g.entries != nil {
+		pw.source, pw.owner = g.source, g.owner
+		for k, v := range g.entries.Iter() {
+			if k == "Filter" || k == "DecodeParms" || k == "Length" || k == "SMask" && g.smask != nil || k == "Mask" && g.mask != nil {
+				continue
+			}
+			b.WriteString(" /" + escapeName(k) + " ")
+			pw.writeObject(v)
+		}
+	}
+	if g.smask != nil {
+		b.WriteString(" /SMask " + pw.genRef(g.smask))
+	}
+	if g.mask != nil {
+		b.WriteString(" /Mask " + pw.genRef(g.mask))
+	}
+	fmt.Fprintf(b, " /Filter /FlateDecode /Length %d>>\nstream\n", len(g.data))
+	b.Write(g.data)
+	b.WriteString("\nendstream")
+}
+
+// imageObject encodes img as an image XObject: 8 bits per component,
+// DeviceGray for gray images and DeviceRGB otherwise, with a soft mask when
+// any pixel is not opaque.
+func imageObject(img image.Image) *genObject {
+	r := img.Bounds()
+	gray := img.ColorModel() == color.GrayModel || img.ColorModel() == color.Gray16Model
+	var pix, alpha bytes.Buffer
+	opaque := true
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if gray {
+				pix.WriteByte(c.R)
+			} else {
+				pix.Write([]byte{c.R, c.G, c.B})
+			}
+			alpha.WriteByte(c.A)
+			opaque = opaque && c.A == 0xff
+		}
+	}
+	dict := func(cs string) string {
+		return fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent 8", r.Dx(), r.Dy(), cs)
+	}
+	g := &genObject{dict: dict("DeviceRGB"), data: deflate(pix.Bytes())}
+	if gray {
+		g.dict = dict("DeviceGray")
+	}
+	if !opaque {
+		g.smask = &genObject{dict: dict("DeviceGray"), data: deflate(alpha.Bytes())}
+	}
+	return g
+}
+
+// deflate returns data Flate-compressed.
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data) // writes to a bytes.Buffer do not fail
+	zw.Close()
+	return buf.Bytes()
+}
+
+// addStamps draws the stamps of an import into tpl: underlays before the
+// page content, overlays after it, each in its own q/Q. The resources they
+// need join tpl.extraRes.
+func (pw *PdfWriter) addStamps(tpl *pdfTemplate, stamps []stamp) error {
+	// Stamps are placed in the template as drawn; undo its /Matrix.
+	inv, ok := tplMatrix(tpl).invert()
+	if !ok {
+		return fmt.Errorf("gofpdi: stamp on a template of size 0")
+	}
+	bbox := tplMatrix(tpl).transformRect([4]float64{tpl.box["llx"], tpl.box["lly"], tpl.box["urx"], tpl.box["ury"]})
+
+	var under, over bytes.Buffer
+	for _, s := range stamps {
+		b := &over
+		if s.Under {
+			b = &under
+		}
+		m := rotation(-s.Rotation).Multiply(Matrix{1, 0, 0, 1, bbox[0] + s.X, bbox[1] + s.Y}).Multiply(inv)
+		fmt.Fprintf(b, "q\n%s\n", m.CM())
+		if s.Opacity > 0 && s.Opacity < 1 {
+			name := fmt.Sprintf("GOFPDIGS%d", len(tpl.extraRes))
+			gs := fmt.Sprintf("<</Type /ExtGState /CA %s /ca %s>>", formatNumber(s.Opacity), formatNumber(s.Opacity))
+			tpl.extraRes = append(tpl.extraRes, resEntry{category: "ExtGState", name: name, token: func() string { return gs }})
+			fmt.Fprintf(b, "/%s gs\n", name)
+		}
+		name := fmt.Sprintf("GOFPDIS%d", len(tpl.extraRes))
+		switch {
+		case s.img != nil:
+			img := s.img
+			tpl.extraRes = append(tpl.extraRes, resEntry{category: "XObject", name: name, token: func() string { return pw.genRef(img) }})
+			fmt.Fprintf(b, "%s 0 0 %s 0 0 cm\n/%s Do\n", formatNumber(s.w), formatNumber(s.h), name)
+		case s.font != "":
+			symbolic, ok := standardFonts[s.font]
+			if !ok {
+				return fmt.Errorf("gofpdi: %q is not a standard 14 font", s.font)
+			}
+			font := "<</Type /Font /Subtype /Type1 /BaseFont /" + s.font
+			if !symbolic {
+				font += " /Encoding /WinAnsiEncoding"
+			}
+			font += ">>"
+			tpl.extraRes = append(tpl.extraRes, resEntry{category: "Font", name: name, token: func() string { return font }})
+			fmt.Fprintf(b, "BT\n/%s %s Tf\n%s %s %s rg\n", name, formatNumber(s.size),
+				formatNumber(s.Color[0]), formatNumber(s.Color[1]), formatNumber(s.Color[2]))
+			writeLiteral(b, encodeText(s.text, symbolic))
+			b.WriteString(" Tj\nET\n")
+		default:
+			if s.template < 0 || s.template >= len(pw.tpls) {
+				return fmt.Errorf("gofpdi: unknown stamp template %d", s.template)
+			}
+			// The stamp was staged before tpl, so PutFormXobjects numbers it
+			// first; putPageContents refuses templates with template stamps.
+			n := s.template
+			tpl.tplStamps = true
+			tpl.extraRes = append(tpl.extraRes, resEntry{category: "XObject", name: name, token: func() string {
+				return fmt.Sprintf("%d 0 R", pw.tplObjIDs[n])
+			}})
+			fmt.Fprintf(b, "/%s Do\n", name)
+		}
+		b.WriteString("Q\n")
+	}
+
+	var content bytes.Buffer
+	content.Write(under.Bytes())
+	if over.Len() > 0 {
+		content.WriteString("q\n")
+		content.Write(tpl.content)
+		content.WriteString("\nQ\n")
+		content.Write(over.Bytes())
+	} else {
+		content.Write(tpl.content)
+	}
+	tpl.content = content.Bytes()
+	return nil
+}
+
+// winAnsiSpecial maps the characters of WinAnsiEncoding in 0x80–0x9F.
+var winAnsiSpecial = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b, 'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+// encodeText encodes s in WinAnsiEncoding, or byte for byte for the symbolic
+// fonts. Characters the encoding lacks, and invalid UTF-8, become '?'.
+func encodeText(s string, symbolic bool) []byte {
+	var out []byte
+	for _, r := range s {
+		switch c, ok := winAnsiSpecial[r]; {
+		case !symbolic && ok:
+			out = append(out, c)
+		case r < 0x80 || r >= 0xa0 && r <= 0xff || symbolic && r <= 0xff:
+			out = append(out, byte(r))
+		default:
+			out = append(out, '?')
+		}
+	}
+	return out
+}
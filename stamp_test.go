@@ -0,0 +1,109 @@
+package gofpdi
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// stampFixture is a one-page PDF, 200 × 100 points.
+func stampFixture() []byte {
+	return buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] /Contents 4 0 R>>",
+		"<</Length 8>>\nstream\n0 0 1 rg\nendstream",
+	}, "")
+}
+
+func TestImportStamps(t *testing.T) {
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(bytes.NewReader(stampFixture())); err != nil {
+		t.Fatal(err)
+	}
+	plain, err := imp.ImportPage(1, "/MediaBox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{R: 255, A: 255})
+	img.Set(1, 0, color.NRGBA{B: 255, A: 128})
+	logo := WithImageStamp(img, 20, 0, Stamp{X: 5, Y: 5, Under: true})
+	draft := WithTextStamp("DRAFT €", "Helvetica-Bold", 48, Stamp{X: 10, Y: 20, Rotation: -90, Opacity: 0.5, Color: [3]float64{1, 0, 0}})
+	tpl, err := imp.ImportPage(1, "/MediaBox", WithRotation(90), logo, draft, WithTemplateStamp(plain, Stamp{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := imp.ImportPage(1, "/MediaBox", logo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := imp.ImportPage(1, "/MediaBox", WithTextStamp("x", "Arial", 12, Stamp{})); err == nil {
+		t.Error("non-standard font accepted")
+	}
+	names, err := imp.PutFormXobjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs := imp.GetImportedObjects()
+	head, content := formContent(t, objs[names["/GOFPDITPL"+itoa(tpl)]])
+
+	// The template is the page turned clockwise, 100 × 200; its /Matrix
+	// [0 -1 1 0 0 200] is undone before each stamp is placed.
+	want := "q\n0 1 -1 0 195 5 cm\n20 0 0 10 0 0 cm\n/GOFPDIS0 Do\nQ\n" +
+		"q\n0 0 1 rg\nQ\n" +
+		"q\n-1 0 0 -1 180 10 cm\n/GOFPDIGS1 gs\nBT\n/GOFPDIS2 48 Tf\n1 0 0 rg\n(DRAFT \\200) Tj\nET\nQ\n" +
+		"q\n0 1 -1 0 200 0 cm\n/GOFPDIS3 Do\nQ\n"
+	if string(content) != want {
+		t.Errorf("content =\n%q\nwant\n%q", content, want)
+	}
+	for _, w := range []string{
+		"/GOFPDIGS1 <</Type /ExtGState /CA 0.5 /ca 0.5>>",
+		"/GOFPDIS2 <</Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding>>",
+		"/GOFPDIS3 " + itoa(names["/GOFPDITPL0"]) + " 0 R",
+	} {
+		if !bytes.Contains(head, []byte(w)) {
+			t.Errorf("resources lack %q: %s", w, head)
+		}
+	}
+
+	// The image and its soft mask are written once for both templates.
+	var images, masks int
+	for _, obj := range objs {
+		if bytes.Contains(obj, []byte("/Subtype /Image /Width 2 /Height 1 /ColorSpace /DeviceRGB")) && bytes.Contains(obj, []byte("/SMask ")) {
+			images++
+		}
+		if bytes.Contains(obj, []byte("/ColorSpace /DeviceGray")) {
+			masks++
+		}
+	}
+	if images != 1 || masks != 1 {
+		t.Errorf("%d images and %d soft masks written, want 1 each", images, masks)
+	}
+	if h, _ := formContent(t, objs[names["/GOFPDITPL"+itoa(again)]]); !strings.Contains(string(h), "/GOFPDIS0 ") {
+		t.Errorf("second template resources: %s", h)
+	}
+}
+
+func TestPutPageContentsTemplateStamp(t *testing.T) {
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(bytes.NewReader(stampFixture())); err != nil {
+		t.Fatal(err)
+	}
+	// The first template's image would be copied if the check came late.
+	logo := WithImageStamp(image.NewGray(image.Rect(0, 0, 1, 1)), 10, 10, Stamp{})
+	plain, _ := imp.ImportPage(1, "/MediaBox", logo)
+	if _, err := imp.ImportPage(1, "/MediaBox", WithTemplateStamp(plain, Stamp{})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := imp.PutPageContents(); err == nil {
+		t.Error("template stamp exported as page content")
+	}
+	if objs := imp.GetImportedObjects(); len(objs) != 0 {
+		t.Errorf("%d objects written before the error", len(objs))
+	}
+}
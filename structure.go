@@ -0,0 +1,291 @@
+package gofpdi
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	src "github.com/speedata/pdfdisassembler"
+)
+
+// The structure elements owning content of an imported page are copied into
+// the host's structure tree. The template keeps the marked content of the
+// page, MCIDs included: a Form XObject with a /StructParents entry has its own
+// MCID numbering and parent tree entry (PDF 32000-1 §14.7.4.4), so the
+// content stream is left as it is and the copied elements refer to it with
+// marked-content references naming the XObject as /Stm.
+
+// StructGraft tells ImportStructure where the copied elements go in the host
+// document.
+type StructGraft struct {
+	// Parent is the object number of the host structure element (or the
+	// StructTreeRoot) the top-most copied elements become children of.
+	Parent int
+	// Page is the object number of the host page that draws the template.
+	Page int
+	// StructParents is the parent tree key the host reserves for the
+	// template. It is written as the Form XObject's /StructParents.
+	StructParents int
+}
+
+// StructTree describes the structure elements copied for a template.
+type StructTree struct {
+	// Roots are the object numbers of the top-most copied elements, the new
+	// children of StructGraft.Parent, in reading order. The host adds them to
+	// the /K of that element.
+	Roots []int
+	// ParentTree maps each MCID of the template to the object number of the
+	// element owning it (0 for none). It is the array the host puts into its
+	// parent tree under StructGraft.StructParents.
+	ParentTree []int
+	// RoleMap is the role map of the source, to be merged into the host's
+	// StructTreeRoot so custom structure types keep their meaning.
+	RoleMap map[string]string
+}
+
+// structSet is one staged structure copy.
+type structSet struct {
+	tpl   int
+	graft StructGraft
+	tree  StructTree // filled by PutFormXobjects
+}
+
+// stageStructure stages the structure of template tplN and returns the index
+// of the new set.
+func (pw *PdfWriter) stageStructure(tplN int, graft StructGraft) (int, error) {
+	if tplN < 0 || tplN >= len(pw.tpls) {
+		return 0, fmt.Errorf("gofpdi: unknown template %d", tplN)
+	}
+	if pw.ExtraTemplateDict == nil {
+		pw.ExtraTemplateDict = make(map[int]map[string]string)
+	}
+	if pw.ExtraTemplateDict[tplN] == nil {
+		pw.ExtraTemplateDict[tplN] = make(map[string]string)
+	}
+	pw.ExtraTemplateDict[tplN]["StructParents"] = strconv.Itoa(graft.StructParents)
+	pw.structSets = append(pw.structSets, &structSet{tpl: tplN, graft: graft})
+	return len(pw.structSets) - 1, nil
+}
+
+// writeStructSet copies the structure elements that own content of the
+// template's page, and their ancestors, into fresh objects.
+func (pw *PdfWriter) writeStructSet(set *structSet) error {
+	tpl := pw.tpls[set.tpl]
+	r := pw.sources[tpl.source]
+	pw.source = tpl.source
+	pw.nullPages = true
+	defer func() { pw.nullPages = false }()
+	cat, err := r.Catalog()
+	if err != nil {
+		return err
+	}
+	root, ok := cat.Dict("StructTreeRoot")
+	if !ok {
+		return nil
+	}
+	if rm, ok := root.Dict("RoleMap"); ok {
+		set.tree.RoleMap = make(map[string]string)
+		for k := range rm.Iter() {
+			if v, ok := rm.Name(k); ok {
+				set.tree.RoleMap[k] = string(v)
+			}
+		}
+	}
+	// Walk the whole tree rather than the parent tree: producers often omit
+	// or botch the latter, and the walk yields the roots in document order.
+	// Owning elements and their ancestors are numbered on first sight.
+	sc := structCopy{pw: pw, r: r, set: set, tpl: tpl, ids: make(map[src.Reference]int),
+		owners: make(map[int]int), seen: make(map[src.Reference]bool), pages: make(map[src.Reference]*src.Dict)}
+	k, _ := root.Get("K")
+	sc.walk(k, nil, nil, 0)
+	for mcid, id := range sc.owners {
+		for len(set.tree.ParentTree) <= mcid {
+			set.tree.ParentTree = append(set.tree.ParentTree, 0)
+		}
+		set.tree.ParentTree[mcid] = id
+	}
+
+	for _, ref := range sc.order {
+		d, _ := resolveIn(r, ref).(*src.Dict)
+		pw.currentObj, pw.owner = new(bytes.Buffer), ref
+		sc.writeElem(d, sc.pages[ref])
+		if pw.err != nil {
+			return pw.err
+		}
+		if err := pw.emit(sc.ids[ref]); err != nil {
+			return err
+		}
+	}
+	return pw.drain()
+}
+
+// structCopy is the state of one writeStructSet call.
+type structCopy struct {
+	pw     *PdfWriter
+	r      *src.Reader
+	set    *structSet
+	tpl    *pdfTemplate
+	ids    map[src.Reference]int // kept elements and their output numbers
+	order  []src.Reference
+	owners map[int]int // MCID -> output number of the owning element
+	seen   map[src.Reference]bool
+	pages  map[src.Reference]*src.Dict // page of each element, /Pg or inherited
+}
+
+// walk visits the structure tree below k, the /K of element elem (nil for
+// the StructTreeRoot), whose page is pg, and keeps every element owning
+// marked content of the template's page.
+func (sc *structCopy) walk(k src.Object, elem *src.Reference, pg *src.Dict, depth int) {
+	if depth > 256 {
+		return
+	}
+	page := sc.tpl.page.Dict()
+	own := func(mcid int64, pg *src.Dict) {
+		if elem != nil && pg == page && mcid >= 0 && mcid < 1<<20 {
+			sc.owners[int(mcid)] = sc.keep(*elem)
+		}
+	}
+	switch kid := k.(type) {
+	case src.Array:
+		for _, e := range kid {
+			sc.walk(e, elem, pg, depth)
+		}
+	case src.Integer:
+		own(int64(kid), pg)
+	case src.Reference:
+		d, ok := resolveIn(sc.r, kid).(*src.Dict)
+		if !ok {
+			return
+		}
+		if !isStructElem(d) {
+			sc.walk(d, elem, pg, depth)
+			return
+		}
+		if sc.seen[kid] {
+			return
+		}
+		sc.seen[kid] = true
+		childPg, ok := d.Dict("Pg")
+		if !ok {
+			childPg = pg
+		}
+		sc.pages[kid] = childPg
+		ref := kid
+		grandkids, _ := d.Get("K")
+		sc.walk(grandkids, &ref, childPg, depth+1)
+	case *src.Dict:
+		if t, _ := kid.Name("Type"); t != "MCR" || kid.Has("Stm") {
+			return
+		}
+		if p, ok := kid.Dict("Pg"); ok {
+			pg = p
+		}
+		if mcid, ok := kid.Int("MCID"); ok {
+			own(mcid, pg)
+		}
+	}
+}
+
+// keep reserves an output number for the element ref and its ancestors and
+// returns the number of ref.
+func (sc *structCopy) keep(ref src.Reference) int {
+	if id, ok := sc.ids[ref]; ok {
+		return id
+	}
+	d, ok := resolveIn(sc.r, ref).(*src.Dict)
+	if !ok || !isStructElem(d) || len(sc.ids) > 1<<16 {
+		return 0
+	}
+	id := sc.pw.reserveObjectID()
+	sc.ids[ref] = id
+	sc.order = append(sc.order, ref)
+	p, _ := d.Get("P")
+	if pref, ok := p.(src.Reference); ok && isStructElem(resolveIn(sc.r, pref)) {
+		sc.keep(pref)
+	} else {
+		sc.set.tree.Roots = append(sc.set.tree.Roots, id)
+	}
+	return id
+}
+
+// isStructElem reports whether obj is a structure element, as opposed to the
+// StructTreeRoot.
+func isStructElem(obj src.Object) bool {
+	d, ok := obj.(*src.Dict)
+	if !ok {
+		return false
+	}
+	t, _ := d.Name("Type")
+	return t != "StructTreeRoot" && d.Has("S")
+}
+
+// writeElem serializes a kept structure element whose page is pg: /P points
+// to the copied parent or the graft point, /K keeps only kids on the
+// template's page.
+func (sc *structCopy) writeElem(d *src.Dict, pg *src.Dict) {
+	pw := sc.pw
+	b := pw.currentObj
+	b.WriteString("<<")
+	for k, v := range d.Iter() {
+		switch k {
+		case "P":
+			id := sc.set.graft.Parent
+			if pref, ok := v.(src.Reference); ok && sc.ids[pref] != 0 {
+				id = sc.ids[pref]
+			}
+			fmt.Fprintf(b, "/P %d 0 R ", id)
+		case "Pg":
+			fmt.Fprintf(b, "/Pg %d 0 R ", sc.set.graft.Page)
+		case "K":
+			b.WriteString("/K [")
+			sc.writeKids(v, pg)
+			b.WriteString("]")
+		case "Ref":
+			// References to elements elsewhere in the source tree.
+		default:
+			b.WriteString("/" + escapeName(k) + " ")
+			pw.writeObject(v)
+		}
+	}
+	b.WriteString(">>")
+}
+
+// writeKids writes the kids in k that belong to the template: MCIDs of its
+// page and kept elements. pg is the page of the parent element.
+func (sc *structCopy) writeKids(k src.Object, pg *src.Dict) {
+	page := sc.tpl.page.Dict()
+	switch kid := k.(type) {
+	case src.Array:
+		for _, e := range kid {
+			sc.writeKids(e, pg)
+		}
+	case src.Integer:
+		if pg == page {
+			sc.writeMCR(int(kid))
+		}
+	case src.Reference:
+		if id := sc.ids[kid]; id != 0 {
+			fmt.Fprintf(sc.pw.currentObj, "%d 0 R ", id)
+			return
+		}
+		if d, ok := resolveIn(sc.r, kid).(*src.Dict); ok {
+			sc.writeKids(d, pg)
+		}
+	case *src.Dict:
+		if t, _ := kid.Name("Type"); t != "MCR" || kid.Has("Stm") {
+			return // object references and content of nested XObjects
+		}
+		if p, ok := kid.Dict("Pg"); ok {
+			pg = p
+		}
+		if mcid, ok := kid.Int("MCID"); ok && pg == page {
+			sc.writeMCR(int(mcid))
+		}
+	}
+}
+
+// writeMCR writes a marked-content reference into the template.
+func (sc *structCopy) writeMCR(mcid int) {
+	fmt.Fprintf(sc.pw.currentObj, "<</Type /MCR /Pg %d 0 R /Stm %d 0 R /MCID %d>> ",
+		sc.set.graft.Page, sc.pw.tplObjIDs[sc.set.tpl], mcid)
+}
@@ -0,0 +1,107 @@
+package gofpdi
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestImportStructure(t *testing.T) {
+	r, err := os.Open("testdata/sample.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(r); err != nil {
+		t.Fatal(err)
+	}
+	tpl, err := imp.ImportPage(1, "/MediaBox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	graft := StructGraft{Parent: 9000, Page: 9001, StructParents: 7}
+	n, err := imp.ImportStructure(tpl, graft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := imp.PutFormXobjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := imp.GetImportedStructure(n)
+	if len(tree.Roots) == 0 || len(tree.ParentTree) == 0 {
+		t.Fatalf("nothing copied: %+v", tree)
+	}
+	objs := imp.GetImportedObjects()
+	xobj := names["/GOFPDITPL0"]
+	if !strings.Contains(string(objs[xobj]), "/StructParents 7") {
+		t.Error("template lacks /StructParents")
+	}
+	for _, root := range tree.Roots {
+		if !strings.Contains(string(objs[root]), "/P 9000 0 R") {
+			t.Errorf("root %d not attached to the graft point: %s", root, objs[root])
+		}
+	}
+	// Every MCID owner is a copied element that refers to the MCID through
+	// the template.
+	mcr := "/Stm " + itoa(xobj) + " 0 R /MCID "
+	for mcid, owner := range tree.ParentTree {
+		if owner == 0 {
+			continue
+		}
+		if !strings.Contains(string(objs[owner]), mcr+itoa(mcid)+">>") {
+			t.Errorf("element %d does not reference MCID %d: %s", owner, mcid, objs[owner])
+		}
+	}
+	// Nothing of the source tree beyond the copied elements is dragged in.
+	for num, body := range objs {
+		if strings.Contains(string(body), "/StructTreeRoot") || strings.Contains(string(body), "/ParentTree") {
+			t.Errorf("object %d is part of the source structure tree root", num)
+		}
+	}
+}
+
+func TestImportStructureInheritedPage(t *testing.T) {
+	// The paragraph has no /Pg of its own; its MCIDs are on the page of the
+	// section around it.
+	content := "/P <</MCID 0>> BDC 0 0 10 10 re f EMC /P <</MCID 1>> BDC 20 0 10 10 re f EMC"
+	pdf := buildPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R /StructTreeRoot 5 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] /Contents 4 0 R /StructParents 0>>",
+		"<</Length " + itoa(len(content)) + ">>\nstream\n" + content + "\nendstream",
+		"<</Type /StructTreeRoot /K 6 0 R>>",
+		"<</Type /StructElem /S /Sect /P 5 0 R /Pg 3 0 R /K 7 0 R>>",
+		"<</Type /StructElem /S /P /P 6 0 R /K [0 1]>>",
+	}, "")
+	imp := NewImporter()
+	imp.SetNextObjectID(1)
+	if err := imp.SetSourceStream(strings.NewReader(string(pdf))); err != nil {
+		t.Fatal(err)
+	}
+	tpl, err := imp.ImportPage(1, "/MediaBox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := imp.ImportStructure(tpl, StructGraft{Parent: 9000, Page: 9001})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := imp.PutFormXobjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := imp.GetImportedStructure(n)
+	if len(tree.ParentTree) != 2 || tree.ParentTree[0] == 0 || tree.ParentTree[0] != tree.ParentTree[1] {
+		t.Fatalf("parent tree = %v, want both MCIDs owned by the paragraph", tree.ParentTree)
+	}
+	p := string(imp.GetImportedObjects()[tree.ParentTree[0]])
+	mcr := "/Stm " + itoa(names["/GOFPDITPL0"]) + " 0 R /MCID "
+	for _, mcid := range []string{"0", "1"} {
+		if !strings.Contains(p, mcr+mcid+">>") {
+			t.Errorf("paragraph lacks MCID %s: %s", mcid, p)
+		}
+	}
+}
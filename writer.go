@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"compress/zlib"
 	"fmt"
-	"math"
+	"io"
 	"strconv"
 	"strings"
 
@@ -17,9 +17,22 @@ import (
 // Streams are copied verbatim — their parameter dictionary plus their raw,
 // still filter-encoded bytes — so image and font data are never re-encoded.
 //
+// A PdfWriter can copy from several source PDFs at once: every template and
+// every queued object remembers the source it belongs to, and all of them
+// share one output numbering.
+//
 // PdfWriter is not safe for concurrent use.
 type PdfWriter struct {
-	reader *src.Reader
+	// sources holds the registered source readers, indexed by source handle;
+	// source is the handle whose objects are being serialized right now.
+	sources []*src.Reader
+	source  int
+	// crypts decrypts the strings and streams of each source, nil for the
+	// unencrypted ones (see sourceCrypt). owner is the indirect object the
+	// values being serialized belong to; its number and generation make up
+	// the decryption key.
+	crypts []*sourceCrypt
+	owner  src.Reference
 
 	tpls []*pdfTemplate
 
@@ -27,7 +40,7 @@ type PdfWriter struct {
 	// assigned to it on first sight; queue holds references discovered but not
 	// yet copied. Keying on the source Reference deduplicates shared objects
 	// (a font referenced by several pages is copied once).
-	refMap map[src.Reference]int
+	refMap map[sourceRef]int
 	queue  []refJob
 
 	// Object numbering: NextObjectID, when set, is the host's allocator;
@@ -37,9 +50,12 @@ type PdfWriter struct {
 	NextObjectID func() int
 
 	// currentObj is the buffer the serializer writes into; writtenObjs
-	// collects each finished object body keyed by its output number.
+	// collects each finished object body keyed by its output number, unless
+	// sink is set, in which case every body is handed to the sink instead and
+	// nothing is retained.
 	currentObj  *bytes.Buffer
 	writtenObjs map[int][]byte
+	sink        ObjectSink
 
 	// err is a sticky error: the recursive serializer cannot return errors, so
 	// the first stream-read failure is recorded here and surfaced by the
@@ -49,28 +65,159 @@ type PdfWriter struct {
 	// ExtraTemplateDict carries additional Form XObject dictionary entries
 	// keyed by template index (see Importer.SetTemplateDictEntry).
 	ExtraTemplateDict map[int]map[string]string
+
+	// Content-hash deduplication (see dedup.go). hashMap maps a content
+	// digest to the output number of the first object emitted with it;
+	// digesting marks objects whose digest is being computed, and while it is
+	// non-empty writeObject writes digests instead of output references.
+	dedup      bool
+	digests    map[sourceRef]objDigest
+	digesting  map[sourceRef]bool
+	digestRefs []sourceRef
+	hashMap    map[[32]byte]int
+	dedupStats DedupStats
+
+	// annotSets are the staged annotation copies (see Importer.ImportAnnots).
+	// refOverride redirects references to the annotations of the set being
+	// written to their fresh copies.
+	annotSets   []*annotSet
+	refOverride map[sourceRef]int
+
+	// structSets are the staged structure copies (see
+	// Importer.ImportStructure); they refer to the templates by the output
+	// numbers in tplObjIDs.
+	structSets []*structSet
+	tplObjIDs  map[int]int
+
+	// genIDs numbers the objects gofpdi generates for stamps (see
+	// stamp.go); genQueue holds those drain has yet to write.
+	genIDs   map[*genObject]int
+	genQueue []*genObject
+
+	// pageTpls maps a source page to the first template staged for it, and
+	// pageNums caches the page numbers of each source's page dictionaries;
+	// both serve destination lookups. destFunc retargets link destinations
+	// (see Importer.SetDestinationFunc).
+	pageTpls map[pageKey]int
+	pageNums map[int]map[*src.Dict]int
+	destFunc func(ResolvedDest) (string, bool)
+
+	// pageObjs caches which references point at page tree nodes. While
+	// nullPages is set (annotation and structure copies) those are not
+	// copied: a /Dest or /Pg pointing at a source page would otherwise drag
+	// in the whole source page tree.
+	pageObjs  map[sourceRef]bool
+	nullPages bool
+}
+
+// ObjectSink receives serialized object bodies as soon as they are finished.
+// body carries no "N 0 obj"/"endobj" wrapper and is only valid during the
+// call. Objects arrive in the order they are completed, which is not
+// necessarily ascending by number.
+type ObjectSink interface {
+	WriteObject(num int, body io.Reader) error
+}
+
+// sourceRef identifies an indirect object within one registered source.
+type sourceRef struct {
+	source int
+	ref    src.Reference
 }
 
 // refJob is a pending object copy: the source reference and the output number
 // already reserved for it.
 type refJob struct {
-	ref   src.Reference
+	sourceRef
 	objID int
 }
 
 // pdfTemplate is a staged page awaiting serialization as a Form XObject.
 type pdfTemplate struct {
-	resources *src.Dict          // resolved page /Resources, inlined into the XObject
-	content   []byte             // decoded page content stream
-	box       map[string]float64 // chosen box (llx/lly/urx/ury/x/y/w/h)
-	rotation  int                // counter-rotation in degrees (0, -90, -180, -270)
+	source     int                // handle of the source the page came from
+	page       *src.Page          // the source page
+	resources  *src.Dict          // resolved page /Resources, inlined into the XObject
+	resOwner   src.Reference      // the object holding resources, for decryption
+	content    []byte             // decoded page content stream
+	box        map[string]float64 // chosen box (llx/lly/urx/ury/x/y/w/h)
+	rotation   int                // counter-rotation in degrees (0, -90, -180, -270)
+	boxName    string             // "/MediaBox", … as requested; "" for a WithClip rectangle
+	clip       bool               // box is a WithClip rectangle rather than a page box
+	transform  Matrix             // user transformation after formMatrix; zero for none
+	rotate     float64            // WithRotation degrees, part of transform
+	prefix     string             // WithResourcePrefix, applied to content and resources
+	extraRes   []resEntry         // resources added to the page's own
+	flattened  map[*src.Dict]bool // annotations drawn into content
+	redactions [][4]float64       // WithRedaction areas; annotations there are not copied
+	dropped    map[string]bool    // page XObjects left out after redaction
+	tplStamps  bool               // draws other templates (WithTemplateStamp)
 }
 
 // NewPdfWriter returns a fully initialized PdfWriter.
 func NewPdfWriter() *PdfWriter {
 	return &PdfWriter{
-		refMap:      make(map[src.Reference]int),
+		refMap:      make(map[sourceRef]int),
 		writtenObjs: make(map[int][]byte),
+		pageTpls:    make(map[pageKey]int),
+		tplObjIDs:   make(map[int]int),
+		genIDs:      make(map[*genObject]int),
+	}
+}
+
+// addSource registers a source reader and returns its handle.
+func (pw *PdfWriter) addSource(r *src.Reader, c *sourceCrypt) int {
+	pw.sources = append(pw.sources, r)
+	pw.crypts = append(pw.crypts, c)
+	return len(pw.sources) - 1
+}
+
+// crypt returns the decryption of source, nil when it is not encrypted.
+func (pw *PdfWriter) crypt(source int) *sourceCrypt {
+	if source < 0 || source >= len(pw.crypts) {
+		return nil
+	}
+	return pw.crypts[source]
+}
+
+// pageContent returns the decoded content of page, its content streams
+// joined by newlines.
+func (pw *PdfWriter) pageContent(source int, page *src.Page) ([]byte, error) {
+	c := pw.crypt(source)
+	if c == nil {
+		return page.Content()
+	}
+	// Streams are always indirect; their references are the decryption keys.
+	r := pw.sources[source]
+	contents, _ := page.Dict().Get("Contents")
+	refs, ok := resolveIn(r, contents).(src.Array)
+	if !ok {
+		refs = src.Array{contents}
+	}
+	var parts [][]byte
+	for _, obj := range refs {
+		ref, ok := obj.(src.Reference)
+		if !ok {
+			continue
+		}
+		s, ok := resolveIn(r, ref).(*src.Stream)
+		if !ok {
+			continue
+		}
+		data, err := c.content(s, ref)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, data)
+	}
+	return bytes.Join(parts, []byte("\n")), nil
+}
+
+// setDedup switches content-hash deduplication on or off.
+func (pw *PdfWriter) setDedup(on bool) {
+	pw.dedup = on
+	if on && pw.digests == nil {
+		pw.digests = make(map[sourceRef]objDigest)
+		pw.digesting = make(map[sourceRef]bool)
+		pw.hashMap = make(map[[32]byte]int)
 	}
 }
 
@@ -98,39 +245,100 @@ func (pw *PdfWriter) setErr(err error) {
 	}
 }
 
-// stageTemplate captures everything needed to emit page as a Form XObject and
-// returns its template index. It reserves no object numbers; numbering happens
-// in PutFormXobjects.
-func (pw *PdfWriter) stageTemplate(page *src.Page, boxName string) (int, error) {
+// stageTemplate captures everything needed to emit page, which belongs to the
+// source with handle source, as a Form XObject and returns its template index.
+// It reserves no object numbers; numbering happens in PutFormXobjects.
+func (pw *PdfWriter) stageTemplate(source int, page *src.Page, boxName string, cfg importConfig) (int, error) {
 	box, err := pageBoxDimensions(page, boxName)
 	if err != nil {
 		return 0, err
 	}
-	content, err := page.Content()
+	if cfg.clip != nil {
+		if box, err = clipBox(page, *cfg.clip); err != nil {
+			return 0, err
+		}
+	}
+	content, err := pw.pageContent(source, page)
 	if err != nil {
 		return 0, fmt.Errorf("gofpdi: read page content: %w", err)
 	}
 	resources, _ := page.Resources() // ok=false -> nil, emitted as <<>>
 
 	tpl := &pdfTemplate{
+		source:    source,
+		page:      page,
 		resources: resources,
+		resOwner:  pw.crypt(source).inheritedOwner(page, "Resources"),
 		content:   content,
 		box:       box,
+		clip:      cfg.clip != nil,
+		rotate:    cfg.rotate,
+	}
+	if !tpl.clip {
+		tpl.boxName = "/" + string(src.BoxName(strings.TrimPrefix(boxName, "/")))
+		if boxName == "" {
+			tpl.boxName = "/" + string(src.MediaBox)
+		}
 	}
 	// PDF /Rotate is clockwise; counter-rotate the content into form space by
 	// the negated angle. Rotation() is already normalized to 0/90/180/270.
 	if angle := page.Rotation(); angle != 0 {
 		tpl.rotation = -angle
 	}
+	bbox := [4]float64{box["llx"], box["lly"], box["urx"], box["ury"]}
+	if m, ok, err := cfg.userTransform(tplMatrix(tpl), bbox); err != nil {
+		return 0, err
+	} else if ok {
+		tpl.transform = m
+	}
+	if len(cfg.hiddenLayers) > 0 {
+		if err := pw.hideLayers(tpl, cfg.hiddenLayers); err != nil {
+			return 0, err
+		}
+	}
+	if cfg.flattenAnnots || cfg.flattenForms {
+		if err := pw.flattenAnnots(tpl, cfg); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(cfg.filters) > 0 {
+		if err := filterContent(tpl, cfg.filters); err != nil {
+			return 0, err
+		}
+	}
+	if len(cfg.redactions) > 0 {
+		if err := pw.redact(tpl, cfg); err != nil {
+			return 0, err
+		}
+	}
+	if len(cfg.stamps) > 0 {
+		if err := pw.addStamps(tpl, cfg.stamps); err != nil {
+			return 0, err
+		}
+	}
+	if cfg.prefix != nil {
+		tpl.prefix = *cfg.prefix
+		if tpl.prefix == "" {
+			tpl.prefix = fmt.Sprintf("GOFPDI%d_", len(pw.tpls))
+		}
+		if tpl.content, err = prefixContent(tpl.content, pw.resourceNames(tpl), tpl.prefix); err != nil {
+			return 0, err
+		}
+	}
 
 	pw.tpls = append(pw.tpls, tpl)
+	key := pageKey{source: source, page: page.Index() + 1}
+	if _, ok := pw.pageTpls[key]; !ok {
+		pw.pageTpls[key] = len(pw.tpls) - 1
+	}
 	return len(pw.tpls) - 1, nil
 }
 
 // PutFormXobjects emits each staged template as a Form XObject and copies the
 // objects reachable from its resources.
 func (pw *PdfWriter) PutFormXobjects() (map[string]int, error) {
-	if pw.reader == nil {
+	if len(pw.sources) == 0 {
 		return nil, fmt.Errorf("gofpdi: no source reader")
 	}
 	result := make(map[string]int, len(pw.tpls))
@@ -153,13 +361,17 @@ func (pw *PdfWriter) PutFormXobjects() (map[string]int, error) {
 		// call, so this must be the first number drawn for the page.
 		xobjID := pw.reserveObjectID()
 		result[fmt.Sprintf("/GOFPDITPL%d", i)] = xobjID
+		pw.tplObjIDs[i] = xobjID
 
+		pw.source = tpl.source
 		pw.currentObj = new(bytes.Buffer)
 		pw.writeFormXObject(tpl, body, i)
 		if pw.err != nil {
 			return nil, pw.err
 		}
-		pw.writtenObjs[xobjID] = pw.currentObj.Bytes()
+		if err := pw.emit(xobjID); err != nil {
+			return nil, err
+		}
 
 		// Copy every object reachable from the resources, in turn discovering
 		// their dependencies, until the queue drains.
@@ -167,6 +379,16 @@ func (pw *PdfWriter) PutFormXobjects() (map[string]int, error) {
 			return nil, err
 		}
 	}
+	for _, set := range pw.annotSets {
+		if err := pw.writeAnnotSet(set); err != nil {
+			return nil, err
+		}
+	}
+	for _, set := range pw.structSets {
+		if err := pw.writeStructSet(set); err != nil {
+			return nil, err
+		}
+	}
 	return result, nil
 }
 
@@ -183,16 +405,13 @@ func (pw *PdfWriter) writeFormXObject(tpl *pdfTemplate, body []byte, tplIndex in
 		fmt.Fprintf(b, "/%s %s\n", k, v)
 	}
 
-	if c, s, tx, ty := formMatrix(tpl); c != 1 || s != 0 || tx != 0 || ty != 0 {
-		fmt.Fprintf(b, "/Matrix [%.5F %.5F %.5F %.5F %.5F %.5F]\n", c, s, -s, c, tx, ty)
+	if m := tplMatrix(tpl); m != IdentityMatrix {
+		fmt.Fprintf(b, "/Matrix [%.5F %.5F %.5F %.5F %.5F %.5F]\n", m[0], m[1], m[2], m[3], m[4], m[5])
 	}
 
 	b.WriteString("/Resources ")
-	if tpl.resources == nil {
-		b.WriteString("<<>>")
-	} else {
-		pw.writeDict(tpl.resources)
-	}
+	pw.owner = tpl.resOwner
+	pw.writeResources(tpl, tpl.prefix)
 	b.WriteByte('\n')
 
 	fmt.Fprintf(b, "/Length %d >>\n", len(body))
@@ -204,33 +423,69 @@ func (pw *PdfWriter) writeFormXObject(tpl *pdfTemplate, body []byte, tplIndex in
 // drain copies each queued source object exactly once, picking up newly
 // discovered references as it goes, until nothing remains.
 func (pw *PdfWriter) drain() error {
+	for len(pw.genQueue) > 0 {
+		g := pw.genQueue[0]
+		pw.genQueue = pw.genQueue[1:]
+		pw.currentObj = new(bytes.Buffer)
+		pw.writeGenObject(g)
+		if err := pw.emit(pw.genIDs[g]); err != nil {
+			return err
+		}
+	}
 	for len(pw.queue) > 0 {
 		job := pw.queue[0]
 		pw.queue = pw.queue[1:]
 
-		obj, err := pw.reader.Resolve(job.ref)
+		obj, err := pw.sources[job.source].Resolve(job.ref)
 		if err != nil {
 			return fmt.Errorf("gofpdi: resolve %d %d R: %w", job.ref.Number, job.ref.Generation, err)
 		}
+		pw.source, pw.owner = job.source, job.ref
 		pw.currentObj = new(bytes.Buffer)
 		pw.writeObject(obj)
 		if pw.err != nil {
 			return pw.err
 		}
-		pw.writtenObjs[job.objID] = pw.currentObj.Bytes()
+		if err := pw.emit(job.objID); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// assignRef returns the output number for a source reference, assigning and
-// queueing it on first sight.
+// emit hands the finished body in currentObj to the sink, or retains it in
+// writtenObjs when no sink is installed.
+func (pw *PdfWriter) emit(objID int) error {
+	if pw.sink == nil {
+		pw.writtenObjs[objID] = pw.currentObj.Bytes()
+		return nil
+	}
+	if err := pw.sink.WriteObject(objID, pw.currentObj); err != nil {
+		return fmt.Errorf("gofpdi: write object %d: %w", objID, err)
+	}
+	pw.currentObj = nil
+	return nil
+}
+
+// assignRef returns the output number for a reference into the current
+// source, assigning and queueing it on first sight. With deduplication on, an
+// object identical to one already emitted reuses that object's number.
 func (pw *PdfWriter) assignRef(ref src.Reference) int {
-	if id, ok := pw.refMap[ref]; ok {
+	key := sourceRef{source: pw.source, ref: ref}
+	if id, ok := pw.refMap[key]; ok {
 		return id
 	}
+	if pw.dedup {
+		if id, ok := pw.dedupRef(key); ok {
+			return id
+		}
+	}
 	id := pw.reserveObjectID()
-	pw.refMap[ref] = id
-	pw.queue = append(pw.queue, refJob{ref: ref, objID: id})
+	pw.refMap[key] = id
+	if pw.dedup {
+		pw.hashMap[pw.digests[key].sum] = id
+	}
+	pw.queue = append(pw.queue, refJob{sourceRef: key, objID: id})
 	return id
 }
 
@@ -244,7 +499,7 @@ func (pw *PdfWriter) writeObject(obj src.Object) {
 	b := pw.currentObj
 	switch o := obj.(type) {
 	case src.Reference:
-		fmt.Fprintf(b, "%d 0 R ", pw.assignRef(o))
+		pw.writeRef(o)
 	case src.Name:
 		b.WriteString("/" + escapeName(string(o)) + " ")
 	case src.Integer:
@@ -258,7 +513,11 @@ func (pw *PdfWriter) writeObject(obj src.Object) {
 			b.WriteString("false ")
 		}
 	case src.String:
-		pw.writePDFString([]byte(o))
+		data, err := pw.crypt(pw.source).decryptString(o, pw.owner)
+		if err != nil {
+			pw.setErr(err)
+		}
+		pw.writePDFString(data)
 	case src.Array:
 		b.WriteByte('[')
 		for _, e := range o {
@@ -276,12 +535,62 @@ func (pw *PdfWriter) writeObject(obj src.Object) {
 	}
 }
 
-// writeDict serializes a dictionary in source insertion order.
+// writeRef serializes a reference into the current source as a reference to
+// its output object. While nullPages is set, references to page tree nodes
+// are written as null.
+func (pw *PdfWriter) writeRef(ref src.Reference) {
+	b := pw.currentObj
+	key := sourceRef{source: pw.source, ref: ref}
+	if id, ok := pw.refOverride[key]; ok {
+		fmt.Fprintf(b, "%d 0 R ", id)
+		return
+	}
+	if pw.nullPages && pw.isPageObject(key) {
+		b.WriteString("null ")
+		return
+	}
+	if len(pw.digesting) > 0 {
+		pw.writeDigestRef(key)
+		return
+	}
+	fmt.Fprintf(b, "%d 0 R ", pw.assignRef(ref))
+}
+
+// isPageObject reports whether key refers to a /Page or /Pages dictionary.
+func (pw *PdfWriter) isPageObject(key sourceRef) bool {
+	if _, ok := pw.refMap[key]; ok {
+		return false
+	}
+	if is, ok := pw.pageObjs[key]; ok {
+		return is
+	}
+	is := false
+	if obj, err := pw.sources[key.source].Resolve(key.ref); err == nil {
+		if d, ok := obj.(*src.Dict); ok {
+			t, _ := d.Name("Type")
+			is = t == "Page" || t == "Pages"
+		}
+	}
+	if pw.pageObjs == nil {
+		pw.pageObjs = make(map[sourceRef]bool)
+	}
+	pw.pageObjs[key] = is
+	return is
+}
+
+// writeDict serializes a dictionary in source insertion order. The /Contents
+// of a signature dictionary is never encrypted and is copied as it is.
 func (pw *PdfWriter) writeDict(d *src.Dict) {
 	b := pw.currentObj
+	t, _ := d.Name("Type")
+	sig := t == "Sig" || t == "DocTimeStamp"
 	b.WriteString("<<")
 	for k, v := range d.Iter() {
 		b.WriteString("/" + escapeName(k) + " ")
+		if s, ok := v.(src.String); ok && sig && k == "Contents" {
+			pw.writePDFString(s)
+			continue
+		}
 		pw.writeObject(v)
 	}
 	b.WriteString(">>")
@@ -291,20 +600,25 @@ func (pw *PdfWriter) writeDict(d *src.Dict) {
 // /Length replaced by the true raw byte count, so an indirect-length source
 // stream stays valid) followed by the raw, undecoded bytes.
 func (pw *PdfWriter) writeStream(s *src.Stream) {
-	raw, err := s.RawBytes()
+	raw, crypt, err := pw.crypt(pw.source).streamBytes(s, pw.owner)
 	if err != nil {
-		pw.setErr(fmt.Errorf("gofpdi: read stream bytes: %w", err))
+		pw.setErr(err)
 		return
 	}
 	b := pw.currentObj
 	b.WriteString("<<")
 	for k, v := range s.Dict.Iter() {
-		if k == "Length" {
-			continue // re-emitted from the actual byte count below
+		if k == "Length" || crypt && (k == "Filter" || k == "DecodeParms") {
+			continue // re-emitted below
 		}
 		b.WriteString("/" + escapeName(k) + " ")
 		pw.writeObject(v)
 	}
+	if crypt {
+		// The decrypted data drops the leading /Crypt filter.
+		filters, parms := streamFilters(s.Dict)
+		writeFilterChain(b, filters[1:], parms[min(1, len(parms)):], func(_ *bytes.Buffer, o src.Object) { pw.writeObject(o) })
+	}
 	fmt.Fprintf(b, "/Length %d>>\n", len(raw))
 	b.WriteString("stream\n")
 	b.Write(raw)
@@ -321,10 +635,14 @@ func (pw *PdfWriter) writeReal(x float64) {
 	pw.currentObj.WriteString(s + " ")
 }
 
-// writePDFString serializes a literal string with binary-safe escaping. Bytes
-// outside the printable ASCII range are written as octal escapes.
+// writePDFString serializes a literal string with binary-safe escaping.
 func (pw *PdfWriter) writePDFString(data []byte) {
-	b := pw.currentObj
+	writeLiteral(pw.currentObj, data)
+}
+
+// writeLiteral writes data to b as a literal string. Bytes outside the
+// printable ASCII range are written as octal escapes.
+func writeLiteral(b *bytes.Buffer, data []byte) {
 	b.WriteByte('(')
 	for _, c := range data {
 		switch c {
@@ -371,17 +689,17 @@ func escapeName(name string) string {
 
 // formMatrix computes the Form XObject /Matrix entries that translate the
 // chosen box's lower-left corner to the form origin and apply the page
-// rotation. The math mirrors the historical gofpdi behaviour.
+// rotation. The math mirrors the historical gofpdi behaviour, which only
+// translates boxes without a zero coordinate; clip rectangles always are.
 func formMatrix(tpl *pdfTemplate) (c, s, tx, ty float64) {
 	c = 1
 	box := tpl.box
-	if box["llx"] != 0 && box["lly"] != 0 && box["urx"] != 0 && box["ury"] != 0 {
+	if tpl.clip || box["llx"] != 0 && box["lly"] != 0 && box["urx"] != 0 && box["ury"] != 0 {
 		tx = -box["llx"]
 		ty = -box["lly"]
 		if tpl.rotation != 0 {
-			angle := float64(tpl.rotation) * math.Pi / 180
-			c = math.Cos(angle)
-			s = math.Sin(angle)
+			r := rotation(float64(tpl.rotation))
+			c, s = r[0], r[1]
 			switch tpl.rotation {
 			case -90:
 				tx = -box["lly"]
@@ -434,6 +752,21 @@ func pageBoxDimensions(page *src.Page, boxName string) (map[string]float64, erro
 	return rectToMap(rect), nil
 }
 
+// clipBox returns the WithClip rectangle r, normalized and clamped to the
+// MediaBox of page.
+func clipBox(page *src.Page, r [4]float64) (map[string]float64, error) {
+	media, ok := page.Box(src.MediaBox)
+	if !ok {
+		return nil, fmt.Errorf("gofpdi: page %d has no /MediaBox", page.Index()+1)
+	}
+	n := normalizeRect(r)
+	rect := intersectRect(src.Rect{LLX: n[0], LLY: n[1], URX: n[2], URY: n[3]}, media)
+	if rect.Width() <= 0 || rect.Height() <= 0 {
+		return nil, fmt.Errorf("gofpdi: clip rectangle [%g %g %g %g] does not overlap the /MediaBox of page %d", r[0], r[1], r[2], r[3], page.Index()+1)
+	}
+	return rectToMap(rect), nil
+}
+
 // intersectRect clamps b so it does not extend beyond media.
 func intersectRect(b, media src.Rect) src.Rect {
 	if b.LLX < media.LLX {